@@ -0,0 +1,71 @@
+package hashfunc
+
+import (
+	"hashtree-mobile/backend"
+	"hashtree-mobile/hashfiles"
+	"strings"
+)
+
+// The hash a repository identifies files with is recorded in its config,
+// <bucket>.cfg, as hash => [ algorithm ]. Repositories created before the
+// hash could be chosen have no config and use sha256.
+
+// algorithm is the hash Initrepo sets new repositories up with.
+var algorithm = hashfiles.SHA256
+
+// SetHashAlgorithm chooses the hash new repositories are created with, one of
+// "sha256", "blake3" or "sha512_256". Existing repositories keep using the
+// hash they were created with. It returns false if name is unknown.
+func SetHashAlgorithm(name string) bool {
+	a, err := hashfiles.ParseAlgorithm(name)
+	if err != nil {
+		return false
+	}
+	algorithm = a
+	return true
+}
+
+// saveconfig writes the config of a repository using alg and returns its
+// name.
+func saveconfig(be backend.Backend, enckey string, bucket string, alg hashfiles.Algorithm) (string, error) {
+	cfg := make(map[string][]string)
+	cfg["hash"] = []string{string(alg)}
+	return bucket + ".cfg", savedb(be, enckey, bucket, bucket+".cfg", cfg)
+}
+
+// repoalgorithm returns the hash the repository in bucket was created with.
+func repoalgorithm(be backend.Backend, enckey string, bucket string) (hashfiles.Algorithm, error) {
+	cfg, err := loaddb(be, enckey, bucket, bucket+".cfg")
+	if err != nil {
+		return "", err
+	}
+	var name string
+	if len(cfg["hash"]) > 0 {
+		name = cfg["hash"][0]
+	}
+	return hashfiles.ParseAlgorithm(name)
+}
+
+// verify reports whether the file at fpath hashes to key, using the
+// algorithm key is tagged with. Keys that aren't content hashes are
+// compared against the sha256 of the file, as they always were.
+func verify(fpath string, key string) (bool, error) {
+	alg, _, ok := hashfiles.ParseKey(key)
+	if !ok {
+		alg = hashfiles.SHA256
+	}
+	sum, err := hashfiles.HashFile(fpath, alg)
+	if err != nil {
+		return false, err
+	}
+	return sum == key, nil
+}
+
+// shortkey shortens a content hash for display, keeping its tag.
+func shortkey(key string) string {
+	if _, _, ok := hashfiles.ParseKey(key); !ok {
+		return key
+	}
+	i := strings.LastIndex(key, "-") + 1
+	return key[:i+8]
+}
@@ -0,0 +1,189 @@
+package hashfunc
+
+import (
+	"fmt"
+	"hashtree-mobile/hashfiles"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// The permissions, modification time, owner and extended attributes of the
+// files of a snapshot <bucket>-<time>.hsh are kept next to it in
+// <bucket>-<time>.attr, in the format path => [ key=value ], see
+// hashfiles.Attr. The directories, symbolic links and named pipes of the
+// snapshot are recorded there as well, with their type, even when metadata
+// is skipped. The sizes of regular files are kept along with their metadata
+// so snapshots can be listed without downloading anything. Snapshots made
+// before file metadata was kept have none and restore with default
+// permissions and the current time.
+
+// skipmetadata makes Hashtree leave out file metadata and Hashseed leave the
+// metadata of restored files alone. The structure of the tree is kept
+// either way.
+var skipmetadata bool
+
+// SetSkipMetadata turns keeping file metadata off or on. When it is off
+// snapshots record only the contents of files and restored files get the
+// default permissions and the current time.
+func SetSkipMetadata(skip bool) {
+	skipmetadata = skip
+}
+
+// attrname returns the name of the file metadata of the snapshot name.
+func attrname(snapshot string) string {
+	return strings.TrimSuffix(snapshot, ".hsh") + ".attr"
+}
+
+// readattrs returns the metadata of the scanned files and the entries that
+// aren't regular files in the format path => [ key=value ], with dir removed
+// from the paths.
+func readattrs(dir string, scanned *hashfiles.Result) map[string][]string {
+	attrdb := make(map[string][]string)
+	read := func(file string, info os.FileInfo) {
+		attr := &hashfiles.Attr{Type: hashfiles.TypeOf(info), Target: scanned.Links[file], Size: -1}
+		if !skipmetadata {
+			var err error
+			attr, err = hashfiles.ReadAttr(file, info)
+			if err != nil {
+				jc.SendString(fmt.Sprint("Unable to read metadata of ", file, ": ", err))
+			}
+		}
+		if leader, ok := scanned.HardLinks[file]; ok {
+			attr.Link = strings.TrimPrefix(leader, dir)
+		}
+		if skipmetadata && attr.Type == "" && attr.Link == "" {
+			return
+		}
+		attrdb[strings.TrimPrefix(file, dir)] = attr.Fields()
+	}
+	for file, info := range scanned.Info {
+		read(file, info)
+	}
+	for file, info := range scanned.Dirs {
+		read(file, info)
+	}
+	for file, info := range scanned.Fifos {
+		read(file, info)
+	}
+	for file := range scanned.Links {
+		info, err := os.Lstat(file)
+		if err != nil {
+			jc.SendString(fmt.Sprint("Unable to scan ", err))
+			continue
+		}
+		read(file, info)
+	}
+	for _, file := range scanned.Skipped {
+		jc.SendString(fmt.Sprint("Skipped device or socket: ", file))
+	}
+	return attrdb
+}
+
+// attrtype returns the type recorded in the metadata fields of an entry, ""
+// for regular files.
+func attrtype(fields []string) string {
+	return attrfield(fields, "type")
+}
+
+// attrlink returns the file a file is a hard link to, "" if it isn't one.
+func attrlink(fields []string) string {
+	return attrfield(fields, "link")
+}
+
+// attrfield returns the value of the field key in the metadata fields of an
+// entry.
+func attrfield(fields []string, key string) string {
+	for _, field := range fields {
+		if strings.HasPrefix(field, key+"=") {
+			return strings.TrimPrefix(field, key+"=")
+		}
+	}
+	return ""
+}
+
+// applyattrs recreates the directories, links, hard links and named pipes of
+// a snapshot below dir and sets the metadata of the restored files. Entries
+// in the way are only replaced if nuke is set. Metadata is only set on the
+// files in done, which were downloaded or found already there, the entries
+// created here and the directories they are in. Files that were left alone
+// or failed to download keep their own. It returns the number of entries
+// that couldn't be created, failing to set metadata is reported but doesn't
+// fail the restore as the contents of the files are there.
+func applyattrs(dir string, attrdb map[string][]string, nuke bool, done map[string]bool) int {
+	attrs := make(map[string]*hashfiles.Attr)
+	var files []string
+	var failed int
+	for file, fields := range attrdb {
+		attr, err := hashfiles.ParseAttr(fields)
+		if err != nil {
+			jc.SendString(fmt.Sprint("Unable to restore ", file, ": ", err))
+			failed++
+			continue
+		}
+		attrs[file] = attr
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if attrs[file].Type == "" {
+			continue
+		}
+		// a directory that was already there only gets its metadata back
+		// if something in it was restored
+		_, err := os.Lstat(dir + file)
+		existed := err == nil
+		if err := attrs[file].Create(dir+file, nuke); err != nil {
+			jc.SendString(fmt.Sprint("Unable to restore ", file, ": ", err))
+			failed++
+			continue
+		}
+		if attrs[file].Type != hashfiles.TypeDir || !existed {
+			done[file] = true
+		}
+	}
+	// files that are hard links to another file are linked to it rather
+	// than downloaded again
+	for _, file := range files {
+		if attrs[file].Link == "" {
+			continue
+		}
+		if !done[attrs[file].Link] {
+			continue
+		}
+		if err := hashfiles.Link(dir+attrs[file].Link, dir+file, nuke); err != nil {
+			jc.SendString(fmt.Sprint("Unable to restore ", file, ": ", err))
+			failed++
+			continue
+		}
+		done[file] = true
+	}
+	if skipmetadata {
+		return failed
+	}
+	for file := range done {
+		for p := path.Dir(file); p != "." && p != "/"; p = path.Dir(p) {
+			done[p] = true
+		}
+	}
+	// the owner can only be changed by root
+	owner := os.Geteuid() == 0
+	var unset int
+	// go backwards so directories are done after what is in them, setting
+	// the metadata of their entries would change their times again
+	for i := len(files) - 1; i >= 0; i-- {
+		fpath := dir + files[i]
+		if !done[files[i]] {
+			continue
+		}
+		if err := attrs[files[i]].Apply(fpath, owner); err != nil {
+			unset++
+			jc.SendString(fmt.Sprint("Unable to restore metadata of ", files[i], ": ", err))
+		}
+	}
+	if unset > 0 {
+		jc.SendString(fmt.Sprint("Unable to restore metadata of ", unset, " files."))
+	}
+	return failed
+}
@@ -0,0 +1,123 @@
+package hashfunc
+
+import (
+	"encoding/json"
+	"fmt"
+	"hashtree-mobile/backend"
+	"hashtree-mobile/hashfiles"
+	"hashtree-mobile/merkle"
+	"hashtree-mobile/writedb"
+	"path"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// A snapshot is browsed from its tree, only the snapshot and its metadata
+// are downloaded. Sizes are known for files backed up since they were
+// recorded in the file metadata.
+
+// entry is a file or directory of a snapshot listing.
+type entry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// Size is the size of a regular file, nil if it isn't known.
+	Size *int64 `json:"size,omitempty"`
+	// Target is the target of a symbolic link.
+	Target string `json:"target,omitempty"`
+	depth  int
+}
+
+// String returns e as a line of an indented listing. Names are escaped the
+// way databases escape them, so every entry stays on its own line.
+func (e entry) String() string {
+	line := strings.Repeat("  ", e.depth) + writedb.Escape(path.Base(e.Path))
+	switch e.Type {
+	case hashfiles.TypeDir:
+		return line + "/"
+	case hashfiles.TypeSymlink:
+		return line + " -> " + writedb.Escape(e.Target)
+	case hashfiles.TypeFifo:
+		return line + "|"
+	}
+	if e.Size != nil {
+		line += "  " + humanize.Bytes(uint64(*e.Size))
+	}
+	return line
+}
+
+// listtree returns the entries of the tree n at p and below it in order,
+// with their metadata from attrdb.
+func listtree(p string, n *merkle.Node, attrdb map[string][]string, depth int) []entry {
+	e := entry{Path: p, Type: "file", depth: depth}
+	switch {
+	case n.IsDir():
+		e.Type = hashfiles.TypeDir
+	case n.Kind != "":
+		e.Type = n.Kind
+	}
+	if attr, err := hashfiles.ParseAttr(attrdb[p]); err == nil {
+		e.Target = attr.Target
+		if e.Type == "file" && attr.Size >= 0 {
+			size := attr.Size
+			e.Size = &size
+		}
+	}
+	var entries []entry
+	if p != "" {
+		entries = append(entries, e)
+		depth++
+	}
+	for _, name := range n.Names() {
+		entries = append(entries, listtree(path.Join(p, name), n.Children[name], attrdb, depth)...)
+	}
+	return entries
+}
+
+// Hashls lists the files of the snapshot below prefix, or all of them if
+// prefix is empty, without restoring them. With asjson set the files are
+// returned as a JSON array of objects with the fields path, type, size and
+// target, otherwise as an indented tree with directories ending in a slash,
+// named pipes in a bar, links shown as "name -> target" and the sizes of
+// files where they are known. Newlines and backslashes in names are escaped
+// as \n and \\ in the tree. It returns "ERROR" if the snapshot can't be
+// read or nothing is at prefix.
+func Hashls(server string, accesskey string, secretkey string, enckey string, bucketname string, secure bool, snapshot string, prefix string, asjson bool) string {
+	be, err := backend.Open(server, accesskey, secretkey, secure)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return hashls(be, enckey, bucketname, snapshot, prefix, asjson)
+}
+
+func hashls(be backend.Backend, enckey string, bucketname string, snapshot string, prefix string, asjson bool) string {
+	root, attrdb, err := loadtree(be, enckey, bucketname, snapshot)
+	if err != nil {
+		jc.SendString(fmt.Sprint("Unable to read ", snapshot, ": ", err))
+		return "ERROR"
+	}
+	prefix = strings.Trim(path.Clean("/"+prefix), "/")
+	n := root.Lookup(prefix)
+	if n == nil {
+		jc.SendString(fmt.Sprint("No such file in ", snapshot, ": ", prefix))
+		return "ERROR"
+	}
+	entries := listtree(prefix, n, attrdb, 0)
+	if asjson {
+		if entries == nil {
+			entries = []entry{}
+		}
+		out, err := json.Marshal(entries)
+		if err != nil {
+			jc.SendString(fmt.Sprintln(err))
+			return "ERROR"
+		}
+		return string(out)
+	}
+	var lines []string
+	for _, e := range entries {
+		lines = append(lines, e.String())
+	}
+	return strings.Join(lines, "\n")
+}
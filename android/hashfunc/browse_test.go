@@ -0,0 +1,39 @@
+package hashfunc
+
+import (
+	"hashtree-mobile/hashfiles"
+	"strings"
+	"testing"
+)
+
+func TestListTree(t *testing.T) {
+	snapshot := map[string][]string{
+		"aaaa": {"docs/report.pdf", "docs/two\nlines.txt"},
+		"bbbb": {"back\\slash"},
+	}
+	attrdb := map[string][]string{
+		"docs/report.pdf": {"size=2048"},
+		"link":            {"type=symlink", "target=docs/report.pdf"},
+		"docs/empty":      {"type=dir"},
+	}
+	root := buildtree(snapshot, attrdb, hashfiles.SHA256)
+	var lines []string
+	for _, e := range listtree("", root, attrdb, 0) {
+		lines = append(lines, e.String())
+	}
+	want := []string{
+		`back\\slash`,
+		`docs/`,
+		`  empty/`,
+		`  report.pdf  2.0 kB`,
+		`  two\nlines.txt`,
+		`link -> docs/report.pdf`,
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+	entries := listtree("docs", root.Lookup("docs"), attrdb, 0)
+	if len(entries) != 4 || entries[1].Path != "docs/empty" || *entries[2].Size != 2048 || entries[3].Size != nil {
+		t.Errorf("got %+v", entries)
+	}
+}
@@ -0,0 +1,96 @@
+package hashfunc
+
+import (
+	"fmt"
+	"hashtree-mobile/chunker"
+	"hashtree-mobile/hashfiles"
+	"io"
+	"os"
+)
+
+// files larger than chunkThreshold are split into content defined chunks so
+// an edit only re-uploads the chunks around it.
+const chunkThreshold = chunker.MaxSize
+
+// chunkfile splits the file at fpath into content defined chunks and returns
+// the key of each chunk, hashed with alg, along with the part of the file it
+// covers.
+func chunkfile(fpath string, alg hashfiles.Algorithm) ([]string, []blob, error) {
+	file, err := os.Open(fpath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var keys []string
+	var blobs []blob
+	c := chunker.New(file)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		h := alg.New()
+		h.Write(chunk.Data)
+		keys = append(keys, alg.Key(h.Sum(nil)))
+		blobs = append(blobs, blob{path: fpath, offset: chunk.Offset, length: int64(chunk.Length)})
+	}
+	return keys, blobs, nil
+}
+
+// planblobs turns a list of files to upload in the format hash -> filepath
+// into the list of blobs to upload. Large files are chunked, their chunk
+// lists are added to chunkdb and only chunks missing from remotedb are
+// uploaded. Chunks are hashed with alg and recorded in remotedb against the
+// file they came from.
+func planblobs(uploadlist map[string]string, remotedb map[string][]string, chunkdb map[string][]string, alg hashfiles.Algorithm) map[string]blob {
+	bloblist := make(map[string]blob)
+	for hash, fpath := range uploadlist {
+		info, err := os.Stat(fpath)
+		if err != nil || info.Size() <= chunkThreshold {
+			bloblist[hash] = blob{path: fpath, length: -1}
+			continue
+		}
+		keys, blobs, err := chunkfile(fpath, alg)
+		if err != nil {
+			jc.SendString(fmt.Sprint("Unable to chunk ", fpath, ": ", err))
+			bloblist[hash] = blob{path: fpath, length: -1}
+			continue
+		}
+		chunkdb[hash] = keys
+		for i, key := range keys {
+			if _, ok := bloblist[key]; !ok && len(remotedb[key]) == 0 {
+				bloblist[key] = blobs[i]
+			}
+			remotedb[key] = removeDuplicates(append(remotedb[key], fpath))
+		}
+	}
+	return bloblist
+}
+
+// dropfailed removes failed uploads from the databases. A file that was
+// chunked can't be restored if any of its chunks is missing, so it is
+// removed as well.
+func dropfailed(failed []string, chunkdb map[string][]string, dbs ...map[string][]string) {
+	missing := make(map[string]bool)
+	for _, hash := range failed {
+		missing[hash] = true
+	}
+	for hash, keys := range chunkdb {
+		for _, key := range keys {
+			if missing[key] {
+				missing[hash] = true
+				delete(chunkdb, hash)
+				break
+			}
+		}
+	}
+	for hash := range missing {
+		for _, db := range dbs {
+			delete(db, hash)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package hashfunc
+
+import (
+	"hashtree-mobile/backend"
+	"hashtree-mobile/readdb"
+	"hashtree-mobile/writedb"
+	"io"
+	"time"
+
+	"github.com/minio/sio"
+)
+
+// Databases are stored like every other object, compressed with lz4 and
+// encrypted with the key of their name. They are streamed to and from the
+// backend through readdb and writedb, so no temporary file is written and
+// the encoded form of a database is never held in memory as a whole.
+//
+// The entries are another matter. Pushes, restores and the index rewrite of
+// garbage collection look hashes up across a whole index, so they read it
+// into a map with fetchdb and their memory grows with the number of entries.
+// Only work that needs one entry at a time uses streamdb and stays bounded,
+// which so far is finding the objects the snapshots refer to.
+
+// fetchdb reads the database name from the repository into memory. It fails
+// with backend.ErrNotFound if there is none.
+func fetchdb(be backend.Backend, enckey string, bucket string, name string) (map[string][]string, error) {
+	db := make(map[string][]string)
+	err := streamdb(be, enckey, bucket, name, func(key string, values []string) error {
+		db[key] = append(db[key], values...)
+		return nil
+	})
+	return db, err
+}
+
+// streamdb calls fn for every entry of the database name in the repository,
+// without holding the database in memory. As the checksum is only checked at
+// the end, fn must not act on the entries before streamdb returned nil.
+func streamdb(be backend.Backend, enckey string, bucket string, name string, fn func(key string, values []string) error) error {
+	obj, err := be.GetObject(bucket, name)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	decrypted, err := sio.DecryptReader(obj, sio.Config{Key: packkey(enckey, bucket, name)})
+	if err != nil {
+		return err
+	}
+	decompressed := decompressLZ4(decrypted)
+	// stop decompressing when reading fails half way
+	defer decompressed.Close()
+	r := readdb.NewReader(decompressed)
+	for {
+		key, values, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(key, values); err != nil {
+			return err
+		}
+	}
+}
+
+// loaddb reads the database name from the repository. A database that
+// doesn't exist yet, as in repositories made before it was introduced, is
+// returned empty.
+func loaddb(be backend.Backend, enckey string, bucket string, name string) (map[string][]string, error) {
+	db, err := fetchdb(be, enckey, bucket, name)
+	if err == backend.ErrNotFound {
+		return make(map[string][]string), nil
+	}
+	return db, err
+}
+
+// savedb writes db to the repository as name, trying a few times before
+// giving up.
+func savedb(be backend.Backend, enckey string, bucket string, name string, db map[string][]string) error {
+	var err error
+	for i := 0; i < 3; i++ {
+		if err = putdb(be, enckey, bucket, name, db); err == nil {
+			return nil
+		}
+		time.Sleep(time.Duration(i) * time.Second)
+	}
+	return err
+}
+
+// putdb streams db to the repository as name.
+func putdb(be backend.Backend, enckey string, bucket string, name string, db map[string][]string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writedb.WriteAll(writedb.NewWriter(pw), db))
+	}()
+	compressed := compressLZ4(pr)
+	// unblock the writers when the upload stops half way
+	defer pr.Close()
+	defer compressed.Close()
+	encrypted, err := sio.EncryptReader(compressed, sio.Config{Key: packkey(enckey, bucket, name)})
+	if err != nil {
+		return err
+	}
+	_, err = be.PutObject(bucket, name, encrypted, -1)
+	return err
+}
@@ -0,0 +1,231 @@
+package hashfunc
+
+import (
+	"encoding/json"
+	"fmt"
+	"hashtree-mobile/backend"
+	"hashtree-mobile/hashfiles"
+	"hashtree-mobile/merkle"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Snapshots are compared by their trees, only the databases are read and no
+// file content is downloaded. A file that is gone from one path and shows up
+// with the same content at another is reported as renamed.
+
+// Kinds of change between two trees.
+const (
+	added    = "added"
+	removed  = "removed"
+	modified = "modified"
+	renamed  = "renamed"
+)
+
+// change is a path that differs between two trees.
+type change struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+	// From is the path a renamed file had before.
+	From string `json:"from,omitempty"`
+}
+
+// String returns change as a line of a listing.
+func (c change) String() string {
+	switch c.Kind {
+	case added:
+		return "+ " + c.Path
+	case removed:
+		return "- " + c.Path
+	case renamed:
+		return "R " + c.From + " -> " + c.Path
+	}
+	return "M " + c.Path
+}
+
+// difftrees returns the changes from tree a to tree b, sorted by path.
+func difftrees(a, b *merkle.Node) []change {
+	gone := make(map[string]*merkle.Node)
+	came := make(map[string]*merkle.Node)
+	var changes []change
+	for _, c := range merkle.Compare(a, b) {
+		switch {
+		case c.A != nil && c.B != nil && !c.A.IsDir() && !c.B.IsDir():
+			changes = append(changes, change{Kind: modified, Path: c.Path})
+		default:
+			leaves(c.Path, c.A, gone)
+			leaves(c.Path, c.B, came)
+		}
+	}
+	// files that moved keep their hash, pair them up in order of path
+	moved := make(map[string][]string)
+	for _, p := range sortedpaths(gone) {
+		if n := gone[p]; !n.IsDir() {
+			moved[n.Hash] = append(moved[n.Hash], p)
+		}
+	}
+	for _, p := range sortedpaths(came) {
+		n := came[p]
+		from := moved[n.Hash]
+		if n.IsDir() || len(from) == 0 {
+			changes = append(changes, change{Kind: added, Path: p})
+			continue
+		}
+		moved[n.Hash] = from[1:]
+		delete(gone, from[0])
+		changes = append(changes, change{Kind: renamed, Path: p, From: from[0]})
+	}
+	for p := range gone {
+		changes = append(changes, change{Kind: removed, Path: p})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// leaves adds the files, links, pipes and empty directories of the tree n
+// at p to list.
+func leaves(p string, n *merkle.Node, list map[string]*merkle.Node) {
+	if n == nil {
+		return
+	}
+	if !n.IsDir() || len(n.Children) == 0 {
+		list[p] = n
+		return
+	}
+	for name, child := range n.Children {
+		leaves(path.Join(p, name), child, list)
+	}
+}
+
+// sortedpaths returns the paths of list in order.
+func sortedpaths(list map[string]*merkle.Node) []string {
+	var paths []string
+	for p := range list {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// localtree scans dir the way Hashtree would and returns its tree.
+func localtree(dir string, bucket string, alg hashfiles.Algorithm) *merkle.Node {
+	cache := hashfiles.NewCache()
+	if !paranoid {
+		c, err := hashfiles.LoadCache(dir + "." + bucket + ".cache")
+		if err == nil {
+			cache = c
+		}
+	}
+	scanner := &hashfiles.Scanner{
+		Root:      dir,
+		Workers:   hashfiles.Workers,
+		Cache:     cache,
+		Algorithm: alg,
+		Rules:     ignorerules,
+		Filter: func(path string, info os.FileInfo) bool {
+			return !strings.HasPrefix(path, dir+"."+bucket+".")
+		},
+	}
+	scanned := scanner.Scan()
+	for _, err := range scanned.Errors {
+		jc.SendString(fmt.Sprint("Unable to scan ", err))
+	}
+	snapshot := make(map[string][]string)
+	for file, hash := range scanned.Files {
+		snapshot[hash] = append(snapshot[hash], strings.TrimPrefix(file, dir))
+	}
+	return buildtree(snapshot, readattrs(dir, scanned), alg)
+}
+
+// formatchanges returns changes as JSON if asjson is set, as a line per
+// change otherwise.
+func formatchanges(changes []change, asjson bool) (string, error) {
+	if asjson {
+		if changes == nil {
+			changes = []change{}
+		}
+		out, err := json.Marshal(changes)
+		return string(out), err
+	}
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Hashdiff returns the files added, removed, modified and renamed from the
+// snapshot from to the snapshot to, without downloading any of them. With
+// asjson set the changes are returned as a JSON array of objects with the
+// fields kind, path and from, otherwise as a line per change such as
+//
+//	M notes/todo.txt
+//
+// with + for added, - for removed, M for modified and R for renamed files,
+// which are shown as "R old -> new". It returns "ERROR" if the snapshots
+// can't be read.
+func Hashdiff(server string, accesskey string, secretkey string, enckey string, bucketname string, secure bool, from string, to string, asjson bool) string {
+	be, err := backend.Open(server, accesskey, secretkey, secure)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return hashdiff(be, enckey, bucketname, from, to, asjson)
+}
+
+func hashdiff(be backend.Backend, enckey string, bucketname string, from string, to string, asjson bool) string {
+	a, _, err := loadtree(be, enckey, bucketname, from)
+	if err != nil {
+		jc.SendString(fmt.Sprint("Unable to read ", from, ": ", err))
+		return "ERROR"
+	}
+	b, _, err := loadtree(be, enckey, bucketname, to)
+	if err != nil {
+		jc.SendString(fmt.Sprint("Unable to read ", to, ": ", err))
+		return "ERROR"
+	}
+	out, err := formatchanges(difftrees(a, b), asjson)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return out
+}
+
+// Hashdifflocal returns the changes from the snapshot to the files in dir
+// as they are now, in the same form as Hashdiff. The files in dir are
+// hashed, nothing is downloaded.
+func Hashdifflocal(server string, accesskey string, secretkey string, enckey string, bucketname string, secure bool, snapshot string, dir string, asjson bool) string {
+	be, err := backend.Open(server, accesskey, secretkey, secure)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return hashdifflocal(be, enckey, bucketname, snapshot, dir, asjson)
+}
+
+func hashdifflocal(be backend.Backend, enckey string, bucketname string, snapshot string, dir string, asjson bool) string {
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	a, _, err := loadtree(be, enckey, bucketname, snapshot)
+	if err != nil {
+		jc.SendString(fmt.Sprint("Unable to read ", snapshot, ": ", err))
+		return "ERROR"
+	}
+	alg, err := repoalgorithm(be, enckey, bucketname)
+	if err != nil {
+		jc.SendString(fmt.Sprint("Unable to read repository config! ", err))
+		return "ERROR"
+	}
+	out, err := formatchanges(difftrees(a, localtree(dir, bucketname, alg)), asjson)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package hashfunc
+
+import (
+	"hashtree-mobile/hashfiles"
+	"hashtree-mobile/merkle"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tree returns the tree of files, given as path => hash, and of the empty
+// directories dirs.
+func tree(files map[string]string, dirs ...string) *merkle.Node {
+	root := merkle.New()
+	for p, hash := range files {
+		root.Add(p, &merkle.Node{Hash: hash})
+	}
+	for _, p := range dirs {
+		root.Add(p, merkle.New())
+	}
+	root.Rehash(hashfiles.SHA256)
+	return root
+}
+
+func TestDiffTrees(t *testing.T) {
+	base := map[string]string{
+		"a.txt":        "h1",
+		"notes/todo":   "h2",
+		"DCIM/x/1.jpg": "h3",
+		"DCIM/x/2.jpg": "h4",
+	}
+	// with returns base with the changes in edits, an empty hash removing
+	// the path
+	with := func(edits map[string]string) map[string]string {
+		files := make(map[string]string)
+		for p, hash := range base {
+			files[p] = hash
+		}
+		for p, hash := range edits {
+			if hash == "" {
+				delete(files, p)
+			} else {
+				files[p] = hash
+			}
+		}
+		return files
+	}
+	tests := []struct {
+		name string
+		a, b *merkle.Node
+		want []string
+	}{
+		{"same", tree(base), tree(base), nil},
+		{"modified", tree(base), tree(with(map[string]string{"notes/todo": "h9"})), []string{"M notes/todo"}},
+		{"added", tree(base), tree(with(map[string]string{"b.txt": "h9"})), []string{"+ b.txt"}},
+		{"removed", tree(base), tree(with(map[string]string{"a.txt": ""})), []string{"- a.txt"}},
+		{"renamed", tree(base), tree(with(map[string]string{"a.txt": "", "notes/a.txt": "h1"})), []string{"R a.txt -> notes/a.txt"}},
+		// a directory that came or went is listed file by file, with its
+		// files paired up with those that moved into or out of it
+		{"added directory", tree(base), tree(with(map[string]string{"new/a": "h8", "new/b/c": "h9"})), []string{"+ new/a", "+ new/b/c"}},
+		{"removed directory", tree(base), tree(with(map[string]string{"DCIM/x/1.jpg": "", "DCIM/x/2.jpg": ""})), []string{"- DCIM/x/1.jpg", "- DCIM/x/2.jpg"}},
+		{"renamed directory", tree(base), tree(with(map[string]string{"DCIM/x/1.jpg": "", "DCIM/x/2.jpg": "", "DCIM/y/1.jpg": "h3", "DCIM/y/2.jpg": "h4"})), []string{"R DCIM/x/1.jpg -> DCIM/y/1.jpg", "R DCIM/x/2.jpg -> DCIM/y/2.jpg"}},
+		{"empty directories", tree(base, "old"), tree(base, "new"), []string{"+ new", "- old"}},
+		// moved and changed at once is a file removed and another added
+		{"renamed and modified", tree(base), tree(with(map[string]string{"a.txt": "", "b.txt": "h9"})), []string{"- a.txt", "+ b.txt"}},
+		// copies are paired in order of path, the rest come and go
+		{"duplicates moved", tree(with(map[string]string{"b.txt": "h1", "c.txt": "h1"})), tree(with(map[string]string{"x/c.txt": "h1"})), []string{"- c.txt", "R b.txt -> x/c.txt"}},
+		{"duplicates copied", tree(base), tree(with(map[string]string{"a.txt": "", "x/1": "h1", "x/2": "h1"})), []string{"R a.txt -> x/1", "+ x/2"}},
+		{"copy added", tree(base), tree(with(map[string]string{"copy.txt": "h1"})), []string{"+ copy.txt"}},
+		// a file replaced by a directory holding it is a rename
+		{"file into directory", tree(base), tree(with(map[string]string{"a.txt": "", "a.txt/a.txt": "h1"})), []string{"R a.txt -> a.txt/a.txt"}},
+		{"directory into file", tree(base), tree(with(map[string]string{"DCIM/x/1.jpg": "", "DCIM/x/2.jpg": "", "DCIM/x": "h3"})), []string{"R DCIM/x/1.jpg -> DCIM/x", "- DCIM/x/2.jpg"}},
+	}
+	for _, test := range tests {
+		// the changes are sorted by the path they end at
+		var got []string
+		for _, c := range difftrees(test.a, test.b) {
+			got = append(got, c.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHashdifflocal(t *testing.T) {
+	RegisterJavaCallback(testlog{t})
+	tmp, err := ioutil.TempDir("", "hashfunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	repo := "file://" + filepath.Join(tmp, "repo")
+	src := filepath.Join(tmp, "src")
+	writetree(t, src, testfiles)
+	if !Initrepo(repo, false, "", "", "password", "test", src) || !Hashtree(repo, "", "", "password", "test", false, src) {
+		t.Fatal("push failed")
+	}
+	snapshot := lastsnapshot(t, Hashlist(repo, false, "", "", "test"))
+	if out := Hashdifflocal(repo, "", "", "password", "test", false, snapshot, src, false); out != "" {
+		t.Errorf("unchanged tree differs:\n%s", out)
+	}
+
+	writetree(t, src, map[string]string{"notes/todo.txt": "buy bread", "new.txt": "new"})
+	if err := os.Rename(filepath.Join(src, "DCIM/2018"), filepath.Join(src, "DCIM/2019")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(src, "notes/empty")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"R DCIM/2018/img1.jpg -> DCIM/2019/img1.jpg",
+		"R DCIM/2018/img2.jpg -> DCIM/2019/img2.jpg",
+		"+ new.txt",
+		"- notes/empty",
+		"M notes/todo.txt",
+	}
+	out := Hashdifflocal(repo, "", "", "password", "test", false, snapshot, src, false)
+	if got := strings.Split(out, "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", out, strings.Join(want, "\n"))
+	}
+	out = Hashdifflocal(repo, "", "", "password", "test", false, snapshot, src, true)
+	if !strings.HasPrefix(out, `[{"kind":"renamed","path":"DCIM/2019/img1.jpg","from":"DCIM/2018/img1.jpg"},`) {
+		t.Errorf("got JSON %s", out)
+	}
+	if out := Hashdifflocal(repo, "", "", "password", "test", false, "missing.hsh", src, false); out != "ERROR" {
+		t.Errorf("missing snapshot gives %q", out)
+	}
+}
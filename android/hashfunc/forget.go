@@ -0,0 +1,247 @@
+package hashfunc
+
+import (
+	"errors"
+	"fmt"
+	"hashtree-mobile/backend"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshots are forgotten according to a retention policy. The snapshots of
+// each device and directory are kept apart, so one phone backing up often
+// doesn't push out the snapshots of another. Forgetting a snapshot removes
+// the snapshot and the files that belong to it, the content it refers to is
+// left for garbage collection.
+
+// policy says which snapshots to keep, all others are forgotten.
+type policy struct {
+	// Last keeps the newest snapshots.
+	Last int
+	// Hourly, Daily, Weekly, Monthly and Yearly keep the newest snapshot
+	// of as many of the latest hours, days, weeks, months and years that
+	// have one.
+	Hourly, Daily, Weekly, Monthly, Yearly int
+	// Tags keeps the snapshots that have one of these tags.
+	Tags []string
+}
+
+// parsepolicy parses a policy of comma separated rules, each of the form
+// last=n, hourly=n, daily=n, weekly=n, monthly=n, yearly=n or tag=name.
+func parsepolicy(s string) (*policy, error) {
+	p := &policy{}
+	counts := map[string]*int{
+		"last":    &p.Last,
+		"hourly":  &p.Hourly,
+		"daily":   &p.Daily,
+		"weekly":  &p.Weekly,
+		"monthly": &p.Monthly,
+		"yearly":  &p.Yearly,
+	}
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid retention rule %q", rule)
+		}
+		key := strings.TrimPrefix(strings.TrimSpace(kv[0]), "keep-")
+		value := strings.TrimSpace(kv[1])
+		if key == "tag" {
+			p.Tags = append(p.Tags, value)
+			continue
+		}
+		count, ok := counts[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown retention rule %q", rule)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid count in retention rule %q", rule)
+		}
+		*count = n
+	}
+	if p.Last == 0 && p.Hourly == 0 && p.Daily == 0 && p.Weekly == 0 && p.Monthly == 0 && p.Yearly == 0 && len(p.Tags) == 0 {
+		return nil, errors.New("the retention policy keeps nothing")
+	}
+	return p, nil
+}
+
+// period returns a function naming the period a time falls in, for a rule
+// keeping one snapshot per period.
+func period(layout string) func(time.Time) string {
+	return func(t time.Time) string {
+		return t.Local().Format(layout)
+	}
+}
+
+// isoweek names the week a time falls in.
+func isoweek(t time.Time) string {
+	year, week := t.Local().ISOWeek()
+	return fmt.Sprintf("%d-%02d", year, week)
+}
+
+// applypolicy splits infos into the snapshots p keeps and the ones it
+// forgets, both newest first. reasons holds the rules that keep each kept
+// snapshot.
+func applypolicy(infos []*snapshotinfo, p *policy) (keep []*snapshotinfo, forget []*snapshotinfo, reasons map[string][]string) {
+	reasons = make(map[string][]string)
+	groups := make(map[string][]*snapshotinfo)
+	var order []string
+	for _, info := range infos {
+		group := info.Host + "\x00" + info.Dir
+		if _, ok := groups[group]; !ok {
+			order = append(order, group)
+		}
+		groups[group] = append(groups[group], info)
+	}
+	rules := []struct {
+		name   string
+		count  int
+		period func(time.Time) string
+	}{
+		{"hourly", p.Hourly, period("2006-01-02 15")},
+		{"daily", p.Daily, period("2006-01-02")},
+		{"weekly", p.Weekly, isoweek},
+		{"monthly", p.Monthly, period("2006-01")},
+		{"yearly", p.Yearly, period("2006")},
+	}
+	for _, group := range order {
+		snapshots := groups[group]
+		sort.SliceStable(snapshots, func(i, j int) bool {
+			return snapshots[i].End.After(snapshots[j].End)
+		})
+		for i, info := range snapshots {
+			if i < p.Last {
+				reasons[info.Name] = append(reasons[info.Name], "last")
+			}
+			for _, tag := range p.Tags {
+				if hastag(info, tag) {
+					reasons[info.Name] = append(reasons[info.Name], "tag "+tag)
+					break
+				}
+			}
+		}
+		for _, rule := range rules {
+			seen := make(map[string]bool)
+			for _, info := range snapshots {
+				if len(seen) == rule.count {
+					break
+				}
+				at := rule.period(info.End)
+				if seen[at] {
+					continue
+				}
+				seen[at] = true
+				reasons[info.Name] = append(reasons[info.Name], rule.name)
+			}
+		}
+	}
+	for _, info := range infos {
+		if len(reasons[info.Name]) > 0 {
+			keep = append(keep, info)
+		} else {
+			forget = append(forget, info)
+		}
+	}
+	newest := func(list []*snapshotinfo) {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].End.After(list[j].End)
+		})
+	}
+	newest(keep)
+	newest(forget)
+	return keep, forget, reasons
+}
+
+// hastag reports whether the snapshot info has tag.
+func hastag(info *snapshotinfo, tag string) bool {
+	for _, t := range info.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotfiles returns the names of the objects that make up the snapshot
+// name. The snapshot itself comes first, removing it first means a failure
+// half way doesn't leave it listed without its files.
+func snapshotfiles(name string) []string {
+	base := strings.TrimSuffix(name, ".hsh")
+	return []string{name, base + ".db", base + ".chk", base + ".pak", base + ".meta", base + ".attr"}
+}
+
+// Hashforget forgets the snapshots in bucketname that the retention policy
+// doesn't keep. The policy is a comma separated list of rules:
+//
+//	last=n     keep the n newest snapshots
+//	hourly=n   keep the newest snapshot of each of the last n hours with one
+//	daily=n    the same for days
+//	weekly=n   the same for weeks
+//	monthly=n  the same for months
+//	yearly=n   the same for years
+//	tag=name   keep the snapshots tagged name
+//
+// Rules apply to the snapshots of each device and directory on their own.
+// With dryrun set nothing is removed. It returns a line per snapshot saying
+// whether it is kept and why or forgotten, or "ERROR".
+func Hashforget(server string, accesskey string, secretkey string, enckey string, bucketname string, secure bool, rules string, dryrun bool) string {
+	be, err := backend.Open(server, accesskey, secretkey, secure)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return hashforget(be, enckey, bucketname, rules, dryrun)
+}
+
+func hashforget(be backend.Backend, enckey string, bucketname string, rules string, dryrun bool) string {
+	p, err := parsepolicy(rules)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	infos, err := snapshotinfos(be, enckey, bucketname)
+	if err != nil {
+		jc.SendString(fmt.Sprintln("Error unable to read snapshots!", err))
+		return "ERROR"
+	}
+	keep, forget, reasons := applypolicy(infos, p)
+	var out []string
+	for _, info := range keep {
+		out = append(out, fmt.Sprint("keep ", info.Name, " (", strings.Join(reasons[info.Name], ", "), ")"))
+	}
+	var failed int
+	for _, info := range forget {
+		if dryrun {
+			out = append(out, fmt.Sprint("would forget ", info.Name))
+			continue
+		}
+		var ferr error
+		for _, name := range snapshotfiles(info.Name) {
+			if err := be.RemoveObject(bucketname, name); err != nil && ferr == nil {
+				ferr = err
+			}
+		}
+		if ferr != nil {
+			failed++
+			jc.SendString(fmt.Sprint("Unable to forget ", info.Name, ": ", ferr))
+			out = append(out, fmt.Sprint("failed ", info.Name))
+			continue
+		}
+		out = append(out, fmt.Sprint("forget ", info.Name))
+	}
+	if dryrun {
+		jc.SendString(fmt.Sprint("Would keep ", len(keep), " snapshots and forget ", len(forget), "."))
+	} else {
+		jc.SendString(fmt.Sprint("Kept ", len(keep), " snapshots, forgot ", len(forget)-failed, ". ", failed, " failed."))
+	}
+	if failed > 0 {
+		return "ERROR"
+	}
+	return strings.Join(out, "\n")
+}
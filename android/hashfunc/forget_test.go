@@ -0,0 +1,141 @@
+package hashfunc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := parsepolicy(" keep-daily = 7 ,last=3, tag=keep,tag=yearly-photos,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &policy{Last: 3, Daily: 7, Tags: []string{"keep", "yearly-photos"}}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+	for _, s := range []string{
+		"",
+		",",
+		"last",
+		"last=",
+		"last=x",
+		"last=-1",
+		"last=0",
+		"forever=3",
+		"daily=7,keep-everything=1",
+	} {
+		if p, err := parsepolicy(s); err == nil {
+			t.Errorf("%q parsed as %+v", s, p)
+		}
+	}
+}
+
+// at returns a snapshot of host ending at the local time given as
+// 2006-01-02 15:04.
+func at(t *testing.T, name string, host string, end string, tags ...string) *snapshotinfo {
+	when, err := time.ParseInLocation("2006-01-02 15:04", end, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &snapshotinfo{Name: name, Host: host, Dir: "/sdcard/", End: when, Tags: tags}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	// a and b fall on the same day, c on the day before and in the same
+	// week, d in the week before, e and f in February, g in January and h
+	// in the year before. f is tagged.
+	infos := []*snapshotinfo{
+		at(t, "c", "phone", "2018-03-09 12:00"),
+		at(t, "a", "phone", "2018-03-10 18:00"),
+		at(t, "b", "phone", "2018-03-10 09:00"),
+		at(t, "d", "phone", "2018-03-01 12:00"),
+		at(t, "e", "phone", "2018-02-20 12:00"),
+		at(t, "f", "phone", "2018-02-05 12:00", "keep"),
+		at(t, "g", "phone", "2018-01-15 12:00"),
+		at(t, "h", "phone", "2017-12-31 12:00"),
+	}
+	tests := []struct {
+		policy string
+		keep   []string
+	}{
+		{"last=2", []string{"a", "b"}},
+		{"hourly=2", []string{"a", "b"}},
+		{"daily=2", []string{"a", "c"}},
+		{"daily=30", []string{"a", "c", "d", "e", "f", "g", "h"}},
+		{"weekly=2", []string{"a", "d"}},
+		{"monthly=3", []string{"a", "e", "g"}},
+		{"yearly=2", []string{"a", "h"}},
+		{"last=1,daily=2", []string{"a", "c"}},
+		{"last=2,daily=1,weekly=2,monthly=3", []string{"a", "b", "d", "e", "g"}},
+		{"keep-last=2,keep-daily=1,keep-weekly=2,keep-monthly=3", []string{"a", "b", "d", "e", "g"}},
+		{"tag=keep", []string{"f"}},
+		{"last=1,tag=keep", []string{"a", "f"}},
+		{"tag=other", nil},
+		{"last=100", []string{"a", "b", "c", "d", "e", "f", "g", "h"}},
+	}
+	for _, test := range tests {
+		p, err := parsepolicy(test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keep, forget, _ := applypolicy(infos, p)
+		if got := names(keep); !reflect.DeepEqual(got, test.keep) {
+			t.Errorf("%s keeps %v, want %v", test.policy, got, test.keep)
+		}
+		if len(keep)+len(forget) != len(infos) {
+			t.Errorf("%s keeps %d and forgets %d of %d snapshots", test.policy, len(keep), len(forget), len(infos))
+		}
+		for i := 1; i < len(forget); i++ {
+			if forget[i].End.After(forget[i-1].End) {
+				t.Errorf("%s forgets %v, not newest first", test.policy, names(forget))
+			}
+		}
+	}
+
+	p, _ := parsepolicy("last=2,daily=1,weekly=2,monthly=3,tag=keep")
+	_, _, reasons := applypolicy(infos, p)
+	want := map[string][]string{
+		"a": {"last", "daily", "weekly", "monthly"},
+		"b": {"last"},
+		"d": {"weekly"},
+		"e": {"monthly"},
+		"f": {"tag keep"},
+		"g": {"monthly"},
+	}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("reasons %v, want %v", reasons, want)
+	}
+}
+
+// TestApplyPolicyGroups checks that the snapshots of each device and
+// directory are kept on their own.
+func TestApplyPolicyGroups(t *testing.T) {
+	tablet := at(t, "tablet", "tablet", "2018-01-01 12:00")
+	music := at(t, "music", "phone", "2018-02-01 12:00")
+	music.Dir = "/sdcard/Music/"
+	infos := []*snapshotinfo{
+		at(t, "phone2", "phone", "2018-03-10 18:00"),
+		at(t, "phone1", "phone", "2018-03-10 09:00"),
+		tablet,
+		music,
+	}
+	p, _ := parsepolicy("last=1")
+	keep, forget, _ := applypolicy(infos, p)
+	if got, want := names(keep), []string{"phone2", "music", "tablet"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keeps %v, want %v", got, want)
+	}
+	if got, want := names(forget), []string{"phone1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("forgets %v, want %v", got, want)
+	}
+}
+
+// names returns the names of infos.
+func names(infos []*snapshotinfo) []string {
+	var list []string
+	for _, info := range infos {
+		list = append(list, info.Name)
+	}
+	return list
+}
@@ -0,0 +1,202 @@
+package hashfunc
+
+import (
+	"fmt"
+	"hashtree-mobile/backend"
+	"hashtree-mobile/hashfiles"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Garbage collection removes what no snapshot refers to any more: content
+// objects, packs none of whose blobs are used and files left over from
+// snapshots that were forgotten. The indexes are rewritten to match before
+// anything is removed, so a collection cut short leaves objects nothing
+// refers to rather than index entries for objects that are gone. It holds
+// an exclusive lock on the repository so no push can rely on content while
+// it is being removed.
+
+// Hashgc removes the objects in bucketname that no snapshot refers to and
+// drops them from the indexes. With dryrun set nothing is changed. It
+// returns a line per object removed and a summary, or "ERROR".
+func Hashgc(server string, accesskey string, secretkey string, enckey string, bucketname string, secure bool, dryrun bool) string {
+	be, err := backend.Open(server, accesskey, secretkey, secure)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return hashgc(be, enckey, bucketname, dryrun)
+}
+
+func hashgc(be backend.Backend, enckey string, bucketname string, dryrun bool) string {
+	lock, err := lockrepo(be, enckey, bucketname, true)
+	if err != nil {
+		jc.SendString(fmt.Sprintln("Unable to lock repository!", err))
+		return "ERROR"
+	}
+	defer lock.unlock()
+
+	used, packs, err := referenced(be, enckey, bucketname)
+	if err != nil {
+		jc.SendString(fmt.Sprintln("Error unable to read snapshots!", err))
+		return "ERROR"
+	}
+	snapshots, err := listsnapshots(be, bucketname)
+	if err != nil {
+		jc.SendString(fmt.Sprintln("Error unable to read snapshots!", err))
+		return "ERROR"
+	}
+	// the files of a snapshot are kept as long as the snapshot is
+	kept := make(map[string]bool)
+	for _, name := range snapshots {
+		for _, file := range snapshotfiles(name) {
+			kept[file] = true
+		}
+	}
+	var garbage []backend.ObjectInfo
+	err = be.ListObjects(bucketname, "", func(object backend.ObjectInfo) error {
+		_, _, content := hashfiles.ParseKey(object.Key)
+		switch {
+		case content && !used[object.Key]:
+		case strings.HasPrefix(object.Key, "pack-") && !packs[object.Key]:
+		case strings.HasPrefix(object.Key, bucketname+"-") && !kept[object.Key]:
+		default:
+			return nil
+		}
+		garbage = append(garbage, object)
+		return nil
+	})
+	if err != nil {
+		jc.SendString(fmt.Sprintln("Error unable to list repository!", err))
+		return "ERROR"
+	}
+
+	var out []string
+	var size uint64
+	for _, object := range garbage {
+		size += uint64(object.Size)
+		if dryrun {
+			out = append(out, fmt.Sprint("would remove ", object.Key))
+		}
+	}
+	if dryrun {
+		jc.SendString(fmt.Sprint("Would remove ", len(garbage), " objects, ", humanize.Bytes(size), "."))
+		out = append(out, fmt.Sprint("would remove ", len(garbage), " objects, ", humanize.Bytes(size)))
+		return strings.Join(out, "\n")
+	}
+
+	if err := pruneindexes(be, enckey, bucketname, used, packs); err != nil {
+		jc.SendString(fmt.Sprintln("Error unable to rewrite the indexes!", err))
+		return "ERROR"
+	}
+	var failed int
+	for _, object := range garbage {
+		if err := be.RemoveObject(bucketname, object.Key); err != nil {
+			failed++
+			size -= uint64(object.Size)
+			jc.SendString(fmt.Sprint("Unable to remove ", object.Key, ": ", err))
+			continue
+		}
+		out = append(out, fmt.Sprint("remove ", object.Key))
+	}
+	jc.SendString(fmt.Sprint("Removed ", len(garbage)-failed, " objects, ", humanize.Bytes(size), ". ", failed, " failed."))
+	out = append(out, fmt.Sprint("removed ", len(garbage)-failed, " objects, ", humanize.Bytes(size)))
+	if failed > 0 {
+		return "ERROR"
+	}
+	return strings.Join(out, "\n")
+}
+
+// referenced returns the keys of the blobs the snapshots in bucket refer to,
+// the files along with their chunks, and the packs holding any of them.
+func referenced(be backend.Backend, enckey string, bucket string) (map[string]bool, map[string]bool, error) {
+	snapshots, err := listsnapshots(be, bucket)
+	if err != nil {
+		return nil, nil, err
+	}
+	chunkdb, err := loaddb(be, enckey, bucket, bucket+".chk")
+	if err != nil {
+		return nil, nil, err
+	}
+	packdb, err := loaddb(be, enckey, bucket, bucket+".pak")
+	if err != nil {
+		return nil, nil, err
+	}
+	used := make(map[string]bool)
+	packs := make(map[string]bool)
+	use := func(key string, packdbs ...map[string][]string) {
+		used[key] = true
+		for _, db := range packdbs {
+			if location := db[key]; len(location) > 0 {
+				packs[location[0]] = true
+			}
+		}
+	}
+	for _, name := range snapshots {
+		base := strings.TrimSuffix(name, ".hsh")
+		chunksnapshot, err := loaddb(be, enckey, bucket, base+".chk")
+		if err != nil {
+			return nil, nil, err
+		}
+		packsnapshot, err := loaddb(be, enckey, bucket, base+".pak")
+		if err != nil {
+			return nil, nil, err
+		}
+		// only the keys of the snapshot are needed, its paths aren't kept
+		err = streamdb(be, enckey, bucket, name, func(hash string, paths []string) error {
+			use(hash, packsnapshot, packdb)
+			keys, ok := chunksnapshot[hash]
+			if !ok {
+				keys = chunkdb[hash]
+			}
+			for _, key := range keys {
+				use(key, packsnapshot, packdb)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return used, packs, nil
+}
+
+// pruneindexes drops the blobs that aren't used and the packs that are
+// going away from the indexes of bucket.
+func pruneindexes(be backend.Backend, enckey string, bucket string, used map[string]bool, packs map[string]bool) error {
+	remotedb, err := fetchdb(be, enckey, bucket, bucket+".db")
+	if err != nil {
+		return err
+	}
+	chunkdb, err := loaddb(be, enckey, bucket, bucket+".chk")
+	if err != nil {
+		return err
+	}
+	packdb, err := loaddb(be, enckey, bucket, bucket+".pak")
+	if err != nil {
+		return err
+	}
+	for hash := range remotedb {
+		if !used[hash] {
+			delete(remotedb, hash)
+		}
+	}
+	for hash := range chunkdb {
+		if !used[hash] {
+			delete(chunkdb, hash)
+		}
+	}
+	for key, location := range packdb {
+		if !used[key] || len(location) == 0 || !packs[location[0]] {
+			delete(packdb, key)
+		}
+	}
+	if err := savedb(be, enckey, bucket, bucket+".db", remotedb); err != nil {
+		return err
+	}
+	if err := savedb(be, enckey, bucket, bucket+".chk", chunkdb); err != nil {
+		return err
+	}
+	return savedb(be, enckey, bucket, bucket+".pak", packdb)
+}
@@ -1,25 +1,21 @@
 package hashfunc
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
+	"hashtree-mobile/backend"
 	"hashtree-mobile/hashfiles"
-	"hashtree-mobile/readdb"
-	"hashtree-mobile/writedb"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
-	"github.com/minio/minio-go"
 	"github.com/minio/sio"
 	"github.com/pierrec/lz4"
 	"golang.org/x/crypto/argon2"
@@ -42,6 +38,32 @@ func TestCall() {
 
 const MAX = 2
 
+// paranoid makes Hashtree ignore the hash cache and read every file again.
+var paranoid bool
+
+// SetParanoid turns paranoid mode on or off. In paranoid mode Hashtree
+// rehashes every file instead of trusting the hash cache.
+func SetParanoid(p bool) {
+	paranoid = p
+}
+
+// ignorerules are applied by Hashtree on top of the .hashtreeignore files
+// found in the tree.
+var ignorerules []*hashfiles.Rule
+
+// SetIgnoreRules sets gitignore style rules, one per line, of files Hashtree
+// skips in addition to the ones in .hashtreeignore files. It returns false
+// if the rules can't be parsed.
+func SetIgnoreRules(rules string) bool {
+	parsed, err := hashfiles.ParseRules("", strings.NewReader(rules))
+	if err != nil {
+		jc.SendString(fmt.Sprint("Invalid ignore rules: ", err))
+		return false
+	}
+	ignorerules = parsed
+	return true
+}
+
 const (
 	// SSE DARE package block size.
 	sseDAREPackageBlockSize = 64 * 1024 // 64KiB bytes
@@ -91,7 +113,8 @@ func getEncryptedSize(size int64) int64 {
 }
 
 // compressLZ4 returns an io.Reader that produces lz4 compressed data from src.
-func compressLZ4(src io.Reader) io.Reader {
+// It must be closed, closing it stops the compression.
+func compressLZ4(src io.Reader) io.ReadCloser {
 	pr, pw := io.Pipe()
 	zw := lz4.NewWriter(pw)
 	go func() {
@@ -101,8 +124,9 @@ func compressLZ4(src io.Reader) io.Reader {
 	return pr
 }
 
-// decompressLZ4 returns an io.Reader that produces lz4 compressed data from src.
-func decompressLZ4(src io.Reader) io.Reader {
+// decompressLZ4 returns an io.Reader that produces the data decompressed from
+// the lz4 stream src. It must be closed, closing it stops the decompression.
+func decompressLZ4(src io.Reader) io.ReadCloser {
 	pr, pw := io.Pipe()
 	zr := lz4.NewReader(src)
 	go func() {
@@ -115,15 +139,16 @@ func decompressLZ4(src io.Reader) io.Reader {
 
 // InitRepo creates the encrypted db file and creates the bucket
 func Initrepo(server string, secure bool, accesskey string, secretkey string, enckey string, bucketname string, dir string) bool {
-	// New returns an Amazon S3 compatible client object. API compatibility (v2 or v4) is automatically
-	// determined based on the Endpoint value.
-	s3Client, err := minio.New(server, accesskey, secretkey, secure)
+	be, err := backend.Open(server, accesskey, secretkey, secure)
 	if err != nil {
 		jc.SendString(fmt.Sprintln(err))
 		return false
 	}
+	return initrepo(be, enckey, bucketname, dir)
+}
 
-	found, err := s3Client.BucketExists(bucketname)
+func initrepo(be backend.Backend, enckey string, bucketname string, dir string) bool {
+	found, err := be.BucketExists(bucketname)
 	if err != nil {
 		jc.SendString(fmt.Sprintln(err))
 		return false
@@ -133,7 +158,7 @@ func Initrepo(server string, secure bool, accesskey string, secretkey string, en
 		jc.SendString("Bucket exists.")
 	} else {
 		jc.SendString("Creating bucket.")
-		err = s3Client.MakeBucket(bucketname, "us-east-1")
+		err = be.MakeBucket(bucketname)
 		if err != nil {
 			jc.SendString(fmt.Sprintln(err))
 			return false
@@ -147,38 +172,25 @@ func Initrepo(server string, secure bool, accesskey string, secretkey string, en
 		dir = strings.Join(strs, "")
 	}
 	var dbname []string
-	var dbnameLocal []string
 	dbname = append(dbname, bucketname)
 	dbname = append(dbname, ".db")
-	dbnameLocal = append(dbnameLocal, dir)
-	dbnameLocal = append(dbnameLocal, ".")
-	dbnameLocal = append(dbnameLocal, strings.Join(dbname, ""))
 	// check if dir exists, create if not
-	basedir := filepath.Dir(strings.Join(dbnameLocal, ""))
-	os.MkdirAll(basedir, os.ModePerm)
+	os.MkdirAll(dir, os.ModePerm)
 
 	// create empty repository
-	file, err := os.Create(strings.Join(dbnameLocal, ""))
-	defer file.Close()
+	err = savedb(be, enckey, bucketname, strings.Join(dbname, ""), make(map[string][]string))
 	if err != nil {
+		jc.SendString(fmt.Sprintln("Failed to upload: ", strings.Join(dbname, "")))
 		jc.SendString(fmt.Sprintln(err))
 		return false
 	}
-	dbuploadlist := make(map[string]string)
-	// add these files to the upload list
-	dbuploadlist[strings.Join(dbname, "")] = strings.Join(dbnameLocal, "")
-	failedUploads, err := Upload(server, 443, secure, accesskey, secretkey, enckey, dbuploadlist, bucketname)
+	// record the hash the repository identifies files with
+	cfgname, err := saveconfig(be, enckey, bucketname, algorithm)
 	if err != nil {
-		for _, hash := range failedUploads {
-			jc.SendString(fmt.Sprintln("Failed to upload: ", hash))
-		}
+		jc.SendString(fmt.Sprintln("Failed to upload: ", cfgname))
+		jc.SendString(fmt.Sprintln(err))
 		return false
 	}
-
-	err = os.Remove(strings.Join(dbnameLocal, ""))
-	if err != nil {
-		jc.SendString(fmt.Sprintln("Error deleting database!", err))
-	}
 	return true
 
 }
@@ -187,34 +199,31 @@ func Initrepo(server string, secure bool, accesskey string, secretkey string, en
 func Hashlist(url string, secure bool, accesskey string, secretkey string, bucket string) string {
 	log.SetFlags(log.Lshortfile)
 
-	// New returns an Amazon S3 compatible client object. API compatibility (v2 or v4) is automatically
-	// determined based on the Endpoint value.
-	s3Client, err := minio.New(url, accesskey, secretkey, secure)
+	be, err := backend.Open(url, accesskey, secretkey, secure)
 	if err != nil {
 		jc.SendString(fmt.Sprint(err))
 		return "ERROR"
 	}
-	// Create a done channel to control 'ListObjects' go routine.
-	doneCh := make(chan struct{})
-
-	// Indicate to our routine to exit cleanly upon return.
-	defer close(doneCh)
+	return hashlist(be, bucket)
+}
 
+func hashlist(be backend.Backend, bucket string) string {
 	// List all objects from a bucket-name with a matching prefix.
 	var snapshots []string
-	for object := range s3Client.ListObjects(bucket, "", secure, doneCh) {
-		if object.Err != nil {
-			jc.SendString(fmt.Sprint(object.Err))
-			return "ERROR"
-		}
+	err := be.ListObjects(bucket, "", func(object backend.ObjectInfo) error {
 		matched, err := regexp.MatchString(".hsh$", object.Key)
 		if err != nil {
-			return "ERROR"
+			return err
 		}
 		if matched == true {
 			snapshots = append(snapshots, object.Key)
 			snapshots = append(snapshots, "\n")
 		}
+		return nil
+	})
+	if err != nil {
+		jc.SendString(fmt.Sprint(err))
+		return "ERROR"
 	}
 	if len(snapshots) > 0 {
 		return strings.Join(snapshots, "\n")
@@ -223,9 +232,21 @@ func Hashlist(url string, secure bool, accesskey string, secretkey string, bucke
 }
 
 // Hashseed deploys a hash tree data structure to a directory creating
-// downloading all the files and verifying the SHA256 hash
+// downloading all the files and verifying them with the hash algorithm of
+// the snapshot. Hashseedpaths restores only part of it.
 func Hashseed(server string, accesskey string, secretkey string, enckey string, databasename string, bucketname string, secure bool, dir string, nuke bool) bool {
 	log.SetFlags(log.Lshortfile)
+	be, err := backend.Open(server, accesskey, secretkey, secure)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return false
+	}
+	return hashseed(be, enckey, databasename, bucketname, dir, nuke, nil)
+}
+
+// hashseed restores the snapshot databasename to dir, only the files
+// matching globs if there are any.
+func hashseed(be backend.Backend, enckey string, databasename string, bucketname string, dir string, nuke bool, globs []*hashfiles.Glob) bool {
 	// check for and add trailing / in folder name
 	var strs []string
 
@@ -236,24 +257,59 @@ func Hashseed(server string, accesskey string, secretkey string, enckey string,
 		dir = strings.Join(strs, "")
 	}
 
-	// download .db from server this contains the hashed
-	var dbnameLocal []string
-	dbnameLocal = append(dbnameLocal, dir)
-	dbnameLocal = append(dbnameLocal, databasename)
-	downloadlist := make(map[string]string)
-	downloadlist[strings.Join(dbnameLocal, "")] = databasename
-
-	// download and check error
-	fmt.Println(dbnameLocal)
-	var remotedb = make(map[string][]string)
-	_, err := Download(server, 443, secure, accesskey, secretkey, enckey, downloadlist, bucketname, nuke)
+	// read the snapshot from the server, it holds hash => paths of
+	// every file in it
+	remotedb, err := fetchdb(be, enckey, bucketname, databasename)
 	if err != nil {
 		fmt.Println("Error unable to download database:", err)
 		jc.SendString(fmt.Sprintln("Error unable to download database!", err))
-	} else {
-		remotedb, err = readdb.Load(strings.Join(dbnameLocal, ""))
+		return false
+	}
+	// snapshots with chunked or packed files have a chunk list and a pack
+	// index next to them
+	chunkdb := make(map[string][]string)
+	packdb := make(map[string][]string)
+	attrdb := make(map[string][]string)
+	if strings.HasSuffix(databasename, ".hsh") {
+		var chkname []string
+		chkname = append(chkname, strings.TrimSuffix(databasename, ".hsh"))
+		chkname = append(chkname, ".chk")
+		chunkdb, err = loaddb(be, enckey, bucketname, strings.Join(chkname, ""))
+		if err != nil {
+			jc.SendString(fmt.Sprintln("Error unable to download chunk list!", err))
+			return false
+		}
+		var pakname []string
+		pakname = append(pakname, strings.TrimSuffix(databasename, ".hsh"))
+		pakname = append(pakname, ".pak")
+		packdb, err = loaddb(be, enckey, bucketname, strings.Join(pakname, ""))
+		if err != nil {
+			jc.SendString(fmt.Sprintln("Error unable to download pack index!", err))
+			return false
+		}
+		// make sure the snapshot is still the one its root hash names
+		meta, err := loaddb(be, enckey, bucketname, metaname(databasename))
+		if err != nil {
+			jc.SendString(fmt.Sprintln("Error unable to download snapshot metadata!", err))
+			return false
+		}
+		attrdb, err = loaddb(be, enckey, bucketname, attrname(databasename))
+		if err != nil {
+			jc.SendString(fmt.Sprintln("Error unable to download file metadata!", err))
+			return false
+		}
+		root, err := checkroot(remotedb, attrdb, meta)
 		if err != nil {
-			jc.SendString(fmt.Sprintln("Error unable to download database!", err))
+			jc.SendString(fmt.Sprintln("Error", err))
+			return false
+		}
+		jc.SendString(fmt.Sprint("Snapshot root hash: ", root.Hash))
+	}
+	// only restore the paths asked for, if any
+	if len(globs) > 0 {
+		remotedb, attrdb = selectpaths(remotedb, attrdb, globs)
+		if len(remotedb) == 0 && len(attrdb) == 0 {
+			jc.SendString(fmt.Sprintln("Error no files in the snapshot match the restore paths!"))
 			return false
 		}
 	}
@@ -263,6 +319,10 @@ func Hashseed(server string, accesskey string, secretkey string, enckey string,
 	for hash, filearray := range remotedb {
 		// build local file tree
 		for _, file := range filearray {
+			// hard links are made once the file they link to is there
+			if attrlink(attrdb[file]) != "" {
+				continue
+			}
 			var f []string
 			f = append(f, dir)
 			f = append(f, file)
@@ -270,15 +330,26 @@ func Hashseed(server string, accesskey string, secretkey string, enckey string,
 		}
 	}
 	// Download files
-	failedDownloads, err := Download(server, 443, secure, accesskey, secretkey, enckey, dlist, bucketname, nuke)
+	restored, failedDownloads, err := download(be, enckey, dlist, bucketname, nuke, chunkdb, packdb)
 	if err != nil {
 		for _, file := range failedDownloads {
 			fmt.Println("Error failed to download: ", file)
 			jc.SendString(fmt.Sprintln(err))
 		}
 	}
+	// recreate the directories, links and named pipes and put the metadata
+	// back once the files are there, files that were left alone keep their
+	// own
+	done := make(map[string]bool)
+	for _, fpath := range restored {
+		done[strings.TrimPrefix(fpath, dir)] = true
+	}
+	failedNodes := applyattrs(dir, attrdb, nuke, done)
 	jc.SendString(fmt.Sprint("Successfully downloaded ", (len(dlist) - len(failedDownloads)), " files. ", len(failedDownloads), " failed."))
-	if len(failedDownloads) > 0 {
+	if failedNodes > 0 {
+		jc.SendString(fmt.Sprint("Unable to restore ", failedNodes, " directories, links or pipes."))
+	}
+	if len(failedDownloads) > 0 || failedNodes > 0 {
 		return false
 	}
 	return true
@@ -289,6 +360,16 @@ func Hashseed(server string, accesskey string, secretkey string, enckey string,
 // time.
 func Hashtree(server string, accesskey string, secretkey string, enckey string, bucketname string, secure bool, dir string) bool {
 	log.SetFlags(log.Lshortfile)
+	be, err := backend.Open(server, accesskey, secretkey, secure)
+	if err != nil {
+		jc.SendString(fmt.Sprint(err))
+		return false
+	}
+	return hashtree(be, enckey, bucketname, dir)
+}
+
+func hashtree(be backend.Backend, enckey string, bucketname string, dir string) bool {
+	start := time.Now()
 	// check for and add trailing / in folder name
 	var strs []string
 
@@ -302,62 +383,104 @@ func Hashtree(server string, accesskey string, secretkey string, enckey string,
 	// create various variables
 	var hashmap = make(map[string][]string)
 	var remotedb = make(map[string][]string)
-	// create hash database name
-	var hashdb []string
-	hashdb = append(hashdb, dir)
-	hashdb = append(hashdb, ".")
-	hashdb = append(hashdb, bucketname)
-	hashdb = append(hashdb, ".hsh")
-	// the default output of files is a byte array and string
-	// this is later changed to string[]=>string
-	var files = make(map[string][sha256.Size]byte)
+	// scan results in the format filepath => hash
+	var files = make(map[string]string)
+
+	// files are hashed with the algorithm the repository was created with
+	alg, err := repoalgorithm(be, enckey, bucketname)
+	if err != nil {
+		jc.SendString(fmt.Sprint("Unable to read repository config! ", err))
+		return false
+	}
+	// garbage collection must not remove content this push relies on
+	lock, err := lockrepo(be, enckey, bucketname, false)
+	if err != nil {
+		jc.SendString(fmt.Sprint("Unable to lock repository! ", err))
+		return false
+	}
+	defer lock.unlock()
 
 	// scan files and return map filepath = hash
-	files = hashfiles.Scan(dir)
+	// the hash cache lives next to the local copy of the database, it
+	// spares rehashing files that haven't changed since the last push
+	var cachename []string
+	cachename = append(cachename, dir)
+	cachename = append(cachename, ".")
+	cachename = append(cachename, bucketname)
+	cachename = append(cachename, ".cache")
+	cache := hashfiles.NewCache()
+	if !paranoid {
+		c, err := hashfiles.LoadCache(strings.Join(cachename, ""))
+		if err != nil {
+			jc.SendString(fmt.Sprint("Unable to read hash cache, rehashing all files. ", err))
+		}
+		cache = c
+	}
+	scanner := &hashfiles.Scanner{
+		Root:      dir,
+		Workers:   hashfiles.Workers,
+		Cache:     cache,
+		Algorithm: alg,
+		Rules:     ignorerules,
+		// skip the files this function keeps next to the tree
+		Filter: func(path string, info os.FileInfo) bool {
+			return !strings.HasPrefix(path, dir+"."+bucketname+".")
+		},
+		Progress: func(count int, path string) {
+			fmt.Printf("\rScanning files: %d", count)
+		},
+	}
+	scanned := scanner.Scan()
+	fmt.Println("")
+	files = scanned.Files
+	for _, err := range scanned.Errors {
+		jc.SendString(fmt.Sprint("Unable to scan ", err))
+	}
 	jc.SendString(fmt.Sprint("Files scanned: ", len(files)))
+	if err := cache.Save(strings.Join(cachename, "")); err != nil {
+		jc.SendString(fmt.Sprint("Unable to save hash cache! ", err))
+	}
+	attrdb := readattrs(dir, scanned)
 
-	// download .db from server this contains the hashed
+	// read .db from server this contains the hashed
 	// of all already uploaded files
 	// it will be appended to and reuploaded with new hashed at the end
 	var dbname []string
-	var dbnameLocal []string
 	dbname = append(dbname, bucketname)
 	dbname = append(dbname, ".db")
-	dbnameLocal = append(dbnameLocal, dir)
-	dbnameLocal = append(dbnameLocal, ".")
-	dbnameLocal = append(dbnameLocal, strings.Join(dbname, ""))
-	downloadlist := make(map[string]string)
-	downloadlist[strings.Join(dbnameLocal, "")] = strings.Join(dbname, "")
-
-	// download and check error
-	// download has the format filename => remotename
-	failedDownloads, err := Download(server, 443, secure, accesskey, secretkey, enckey, downloadlist, bucketname, true)
-	if err != nil {
-		for _, file := range failedDownloads {
-			jc.SendString(fmt.Sprint(err))
-			jc.SendString(fmt.Sprint("Error failed to download: ", file))
-		}
-		jc.SendString(fmt.Sprint(err))
+	remotedb, err = fetchdb(be, enckey, bucketname, strings.Join(dbname, ""))
+	if err == backend.ErrNotFound {
 		jc.SendString("Error: Unable to download database. Hash the database been initialised?")
 		return false
 	}
-	// read database
-	remotedb, err = readdb.Load(strings.Join(dbnameLocal, ""))
 	if err != nil {
 		jc.SendString(fmt.Sprint("Unable to read database!", err))
 		return false
 	}
+	// the chunk index holds the chunk list of every file that was split up
+	// it will be appended to and reuploaded along with the database
+	var chkname []string
+	chkname = append(chkname, bucketname)
+	chkname = append(chkname, ".chk")
+	chunkdb, err := loaddb(be, enckey, bucketname, strings.Join(chkname, ""))
+	if err != nil {
+		jc.SendString(fmt.Sprint("Unable to read chunk index!", err))
+		return false
+	}
+	// the pack index holds the location of every blob stored in a pack
+	var pakname []string
+	pakname = append(pakname, bucketname)
+	pakname = append(pakname, ".pak")
+	packdb, err := loaddb(be, enckey, bucketname, strings.Join(pakname, ""))
+	if err != nil {
+		jc.SendString(fmt.Sprint("Unable to read pack index!", err))
+		return false
+	}
 
-	// create out map of [sha256hash] => array of file names
+	// create out map of [hash] => array of file names
 	for file, hash := range files {
 		// build local file tree
-		s := hex.EncodeToString(hash[:])
-		v := hashmap[hex.EncodeToString(hash[:])]
-		if len(v) == 0 {
-			hashmap[s] = append(hashmap[s], file)
-		} else {
-			hashmap[s] = append(hashmap[s], file)
-		}
+		hashmap[hash] = append(hashmap[hash], file)
 	}
 	// create map of files for upload
 	// do this with the full path of each file before it's
@@ -368,13 +491,8 @@ func Hashtree(server string, accesskey string, secretkey string, enckey string,
 		// convert hex to ascii
 		// use first file in list for upload
 		v := remotedb[hash]
-		// check if database filenames
-		if filearray[0] == strings.Join(hashdb, "") {
-			continue
-		} else if filearray[0] == strings.Join(dbnameLocal, "") {
-			continue
-			// this file exist remotely
-		} else if len(v) == 0 {
+		// this file exist remotely
+		if len(v) == 0 {
 			uploadlist[hash] = filearray[0]
 			// file exists remotely
 		} else {
@@ -403,39 +521,111 @@ func Hashtree(server string, accesskey string, secretkey string, enckey string,
 			hashmapcooked[hash] = append(hashmapcooked[hash], f)
 
 		}
+		// keep the paths in order so the same tree gives the same snapshot
+		sort.Strings(hashmapcooked[hash])
 	}
 	// add extra file to remotedb before uploading it
 	for file, hash := range files {
 		// update remotedb with new files
-		s := hex.EncodeToString(hash[:])
-		v := remotedb[s]
-		// remote base name
-
-		if len(v) == 0 {
-			remotedb[s] = append(remotedb[s], file)
-		} else {
-			remotedb[s] = append(remotedb[s], file)
-		}
-		remotedb[s] = removeDuplicates(remotedb[s])
-
+		remotedb[hash] = append(remotedb[hash], file)
+		remotedb[hash] = removeDuplicates(remotedb[hash])
+		sort.Strings(remotedb[hash])
 	}
 
+	// split large files into chunks and group small ones into packs
+	bloblist := planblobs(uploadlist, remotedb, chunkdb, alg)
+	packs, packed := planpacks(bloblist)
+
 	// upload and check error
-	failedUploads, err := Upload(server, 443, secure, accesskey, secretkey, enckey, uploadlist, bucketname)
+	failedUploads, err := uploadblobs(be, enckey, bloblist, bucketname)
+	failedPacked, perr := uploadpacks(be, enckey, packed, packs, bucketname, packdb)
+	failedUploads = append(failedUploads, failedPacked...)
+	if err == nil {
+		err = perr
+	}
 	if err != nil {
 		for _, hash := range failedUploads {
-			// remove failed uploads from database
 			jc.SendString(fmt.Sprint("Failed to upload: ", hash))
-
-			delete(remotedb, hash)
-			delete(hashmapcooked, hash)
-
 		}
+		// remove failed uploads from database
+		dropfailed(failedUploads, chunkdb, remotedb, hashmapcooked)
 		jc.SendString(fmt.Sprint(err))
 	}
-	jc.SendString(fmt.Sprint("Successfully uploaded ", (len(uploadlist) - len(failedUploads)), " files. ", len(failedUploads), " failed."))
+	jc.SendString(fmt.Sprint("Successfully uploaded ", (len(bloblist) + len(packed) - len(failedUploads)), " objects. ", len(failedUploads), " failed."))
+	// describe the snapshot, its files and what had to be uploaded for it
+	info := &snapshotinfo{
+		Host:    devicename(),
+		Dir:     dir,
+		Start:   start,
+		Version: Version,
+		Tags:    tags,
+	}
+	for _, filearray := range hashmapcooked {
+		for _, file := range filearray {
+			info.Files++
+			if fileinfo, ok := scanned.Info[dir+file]; ok {
+				info.Bytes += fileinfo.Size()
+			}
+		}
+	}
+	failed := make(map[string]bool)
+	for _, hash := range failedUploads {
+		failed[hash] = true
+	}
+	for _, list := range []map[string]blob{bloblist, packed} {
+		for hash, b := range list {
+			if failed[hash] {
+				continue
+			}
+			if b.length >= 0 {
+				info.Uploaded += b.length
+			} else if fileinfo, ok := scanned.Info[b.path]; ok {
+				info.Uploaded += fileinfo.Size()
+			}
+		}
+	}
+	info.Parent, err = findparent(be, enckey, bucketname, info.Host, info.Dir)
+	if err != nil {
+		jc.SendString(fmt.Sprint("Unable to find the previous snapshot! ", err))
+	}
+	// the snapshot carries the chunk lists of its files and the location
+	// of the ones that are packed
+	chunksnapshot := make(map[string][]string)
+	packsnapshot := make(map[string][]string)
+	for hash := range hashmapcooked {
+		keys, ok := chunkdb[hash]
+		if ok {
+			chunksnapshot[hash] = keys
+		} else {
+			keys = []string{hash}
+		}
+		for _, key := range keys {
+			if location, ok := packdb[key]; ok {
+				packsnapshot[key] = location
+			}
+		}
+	}
+	// keep the metadata of the files that made it into the snapshot along
+	// with the directories, links and pipes
+	attrsnapshot := make(map[string][]string)
+	for file, attr := range attrdb {
+		if attrtype(attr) != "" {
+			attrsnapshot[file] = attr
+		}
+	}
+	for _, filearray := range hashmapcooked {
+		for _, file := range filearray {
+			if attr, ok := attrdb[file]; ok {
+				attrsnapshot[file] = attr
+			}
+		}
+	}
+	// the root of the snapshot's tree identifies the snapshot
+	root := buildtree(hashmapcooked, attrsnapshot, alg)
+	jc.SendString(fmt.Sprint("Snapshot root hash: ", root.Hash))
 	// create database and upload
 	t := time.Now()
+	info.End = t
 	// create a snapshot of the database
 	// create a snapshot of the hash tree
 	var reponame []string
@@ -450,44 +640,53 @@ func Hashtree(server string, accesskey string, secretkey string, enckey string,
 	reponame = append(reponame, t.Format("2006-01-02_15:04:05"))
 	reponame = append(reponame, ".hsh")
 
-	// write localdb to hard drive
-	err = writedb.Dump(strings.Join(hashdb, ""), hashmapcooked)
-	if err != nil {
-		jc.SendString(fmt.Sprint("Error writing to database!", err))
-		return false
-	}
-
-	// write remotedb to file
-	err = writedb.Dump(strings.Join(dbnameLocal, ""), remotedb)
-	if err != nil {
-		jc.SendString(fmt.Sprint("Error writing to database!", err))
-		return false
+	var chksnapshot []string
+	chksnapshot = append(chksnapshot, bucketname)
+	chksnapshot = append(chksnapshot, "-")
+	chksnapshot = append(chksnapshot, t.Format("2006-01-02_15:04:05"))
+	chksnapshot = append(chksnapshot, ".chk")
+
+	var paksnapshot []string
+	paksnapshot = append(paksnapshot, bucketname)
+	paksnapshot = append(paksnapshot, "-")
+	paksnapshot = append(paksnapshot, t.Format("2006-01-02_15:04:05"))
+	paksnapshot = append(paksnapshot, ".pak")
+
+	var metasnapshot []string
+	metasnapshot = append(metasnapshot, bucketname)
+	metasnapshot = append(metasnapshot, "-")
+	metasnapshot = append(metasnapshot, t.Format("2006-01-02_15:04:05"))
+	metasnapshot = append(metasnapshot, ".meta")
+
+	var attrsnapshotname []string
+	attrsnapshotname = append(attrsnapshotname, bucketname)
+	attrsnapshotname = append(attrsnapshotname, "-")
+	attrsnapshotname = append(attrsnapshotname, t.Format("2006-01-02_15:04:05"))
+	attrsnapshotname = append(attrsnapshotname, ".attr")
+
+	// upload the indexes first and the snapshot last, a snapshot is only
+	// listed once everything it refers to is there
+	dbuploadlist := []struct {
+		name string
+		db   map[string][]string
+	}{
+		{strings.Join(dbname, ""), remotedb},
+		{strings.Join(chkname, ""), chunkdb},
+		{strings.Join(pakname, ""), packdb},
+		{strings.Join(dbsnapshot, ""), remotedb},
+		{strings.Join(chksnapshot, ""), chunksnapshot},
+		{strings.Join(paksnapshot, ""), packsnapshot},
+		{strings.Join(metasnapshot, ""), snapshotmeta(root, alg, scanned.Rules, info)},
+		{strings.Join(attrsnapshotname, ""), attrsnapshot},
+		{strings.Join(reponame, ""), hashmapcooked},
 	}
-
-	dbuploadlist := make(map[string]string)
-	// add these files to the upload list
-	dbuploadlist[strings.Join(reponame, "")] = strings.Join(hashdb, "")
-	dbuploadlist[strings.Join(dbname, "")] = strings.Join(dbnameLocal, "")
-	dbuploadlist[strings.Join(dbsnapshot, "")] = strings.Join(dbnameLocal, "")
-	failedUploads, err = Upload(server, 443, secure, accesskey, secretkey, enckey, dbuploadlist, bucketname)
-	if err != nil {
-		for _, hash := range failedUploads {
-			jc.SendString(fmt.Sprint("Failed to upload: ", hash))
-		}
-		jc.SendString(fmt.Sprint(err))
-		err = os.Remove(strings.Join(hashdb, ""))
-		err = os.Remove(strings.Join(dbnameLocal, ""))
-		if err != nil {
-			jc.SendString(fmt.Sprint("Error deleting database!", err))
+	for _, upload := range dbuploadlist {
+		// databases are streamed to the server as they are written
+		if err := savedb(be, enckey, bucketname, upload.name, upload.db); err != nil {
+			jc.SendString(fmt.Sprint("Failed to upload: ", upload.name))
+			jc.SendString(fmt.Sprint(err))
+			return false
 		}
-		return false
-	}
-
-	err = os.Remove(strings.Join(hashdb, ""))
-	err = os.Remove(strings.Join(dbnameLocal, ""))
-	if err != nil {
-		jc.SendString(fmt.Sprint("Error deleting database!", err))
-		return false
 	}
 	return true
 }
@@ -513,15 +712,36 @@ func removeDuplicates(elements []string) []string {
 
 // Download a list of file in format name => dest
 func Download(url string, port int, secure bool, accesskey string, secretkey string, enckey string, filelist map[string]string, bucket string, nuke bool) ([]string, error) {
+	be, err := backend.Open(url, accesskey, secretkey, secure)
+	if err != nil {
+		return nil, err
+	}
+	_, failed, err := download(be, enckey, filelist, bucket, nuke, nil, nil)
+	return failed, err
+}
+
+// fetched is what became of one file of a download.
+type fetched struct {
+	path string
+	// hash is the hash of the file if it couldn't be restored, "" if it
+	// was written or already there.
+	hash string
+}
+
+// download fetches a list of files in the format name => hash. Files found in
+// chunks are put back together from their chunks, blobs found in packs are
+// read from their pack. It returns the paths that were written or found
+// already there, and the hashes of the files that failed.
+func download(be backend.Backend, enckey string, filelist map[string]string, bucket string, nuke bool, chunks map[string][]string, packs map[string][]string) ([]string, []string, error) {
 	// break up map into 5 parts
 	jobs := make(chan map[string]string, MAX)
-	results := make(chan string, len(filelist))
+	results := make(chan fetched, len(filelist))
 	// reset progress bar
 
 	// This starts up MAX workers, initially blocked
 	// because there are no jobs yet.
 	for w := 1; w <= MAX; w++ {
-		go downloadfile(bucket, url, secure, accesskey, secretkey, enckey, w, nuke, jobs, int32(len(filelist)), results)
+		go downloadfile(be, bucket, enckey, w, nuke, chunks, packs, jobs, int32(len(filelist)), results)
 	}
 
 	// Here we send MAX `jobs` and then `close` that
@@ -533,7 +753,8 @@ func Download(url string, port int, secure bool, accesskey string, secretkey str
 	}
 	close(jobs)
 
-	var grmsgs []string
+	var grmsgs []fetched
+	var restored []string
 	var failed []string
 	// Finally we collect all the results of the work.
 	for a := 1; a <= len(filelist); a++ {
@@ -543,11 +764,12 @@ func Download(url string, port int, secure bool, accesskey string, secretkey str
 	var count float64
 	var errCount float64
 	for _, msg := range grmsgs {
-		if msg != "" {
+		if msg.hash != "" {
 			errCount++
-			failed = append(failed, msg)
+			failed = append(failed, msg.hash)
 		} else {
 			count++
+			restored = append(restored, msg.path)
 		}
 	}
 	//fmt.Println(count, " files downloaded successfully.")
@@ -555,40 +777,38 @@ func Download(url string, port int, secure bool, accesskey string, secretkey str
 	if errCount != 0 {
 		out := fmt.Sprintf("Failed to download: %v files", errCount)
 		fmt.Println(out)
-		return failed, errors.New(out)
+		return restored, failed, errors.New(out)
 	}
-	return failed, nil
+	return restored, failed, nil
 
 }
 
-func downloadfile(bucket string, url string, secure bool, accesskey string, secretkey string, enckey string, id int, nuke bool, jobs <-chan map[string]string, numjobs int32, results chan<- string) {
+func downloadfile(be backend.Backend, bucket string, enckey string, id int, nuke bool, chunks map[string][]string, packs map[string][]string, jobs <-chan map[string]string, numjobs int32, results chan<- fetched) {
 	for j := range jobs {
 		// hash is reversed: filepath => hash
 		for fpath, hash := range j {
 			if _, err := os.Stat(fpath); err == nil {
-				data, err := ioutil.ReadFile(fpath)
+				match, err := verify(fpath, hash)
 				if err != nil {
 					out := fmt.Sprintf("[!] %s => %s failed to verify: %s", hash, fpath, err)
 					fmt.Println(out)
 					jc.SendString(out)
-					results <- hash
+					results <- fetched{path: fpath, hash: hash}
 					break
 				}
 
-				digest := sha256.Sum256(data)
-				checksum := hex.EncodeToString(digest[:])
-				if hash == checksum {
+				if match {
 					b := path.Base(fpath)
-					out := fmt.Sprintf("[V]\t%s => %s", hash[:8], b)
+					out := fmt.Sprintf("[V]\t%s => %s", shortkey(hash), b)
 					fmt.Println(out)
 					jc.SendString(out)
-					results <- ""
+					results <- fetched{path: fpath}
 					break
-				} else if (hash != checksum) && (nuke == false) {
+				} else if !match && (nuke == false) {
 					out := fmt.Sprintf("[!] %s => %s local file differs from remote version!", hash, fpath)
 					fmt.Println(out)
 					jc.SendString(out)
-					results <- hash
+					results <- fetched{path: fpath, hash: hash}
 					break
 
 				}
@@ -598,121 +818,74 @@ func downloadfile(bucket string, url string, secure bool, accesskey string, secr
 			b := path.Base(fpath)
 			basedir := filepath.Dir(fpath)
 			os.MkdirAll(basedir, os.ModePerm)
-			////
-			// minio-go download object code:
-			// Encrypt file content and upload to the server
+			// a file is either stored as a single object named after its
+			// hash or, if it was split up, as the list of its chunks.
+			blobs, chunked := chunks[hash]
+			if !chunked {
+				blobs = []string{hash}
+			}
 			// try multiple times
 			for i := 0; i < 3; i++ {
-				s3Client, err := minio.New(url, accesskey, secretkey, secure)
-				// break unrecoverable errors
-				if err != nil {
-					out := fmt.Sprintf("[!] %s => %s failed to download: %s", hash, fpath, err)
-					fmt.Println(out)
-					jc.SendString(out)
-					results <- hash
-					break
-				}
 				start := time.Now()
-				obj, err := s3Client.GetObject(bucket, hash, minio.GetObjectOptions{})
-				if err != nil {
-					out := fmt.Sprintf("[!] %s => %s failed to download: %s", hash, fpath, err)
-					fmt.Println(out)
-					jc.SendString(out)
-					if i == 2 {
-						results <- hash
-						break
-					}
-					continue
-				}
-				// size not used. we don't know the decrypted and decompressed
-				// size so just ignore it. errors will be caught by the hash
-				// check.
-				/*size, err := decryptedSize(objSt.Size)
-
-				objSt, err := obj.Stat()
-				if err != nil {
-					out := fmt.Sprintf("[!] %s => %s failed to download: %s", hash, fpath, err)
-					fmt.Println(out)
-					results <- hash
-					break
-				}
-				if err != nil {
-					out := fmt.Sprintf("[!] %s => %s failed to download: %s", hash, fpath, err)
-					fmt.Println(out)
-					results <- hash
-					break
-				}*/
 				localFile, err := os.Create(fpath)
 				if err != nil {
 					out := fmt.Sprintf("[!] %s => %s Error creating file.", hash, fpath)
 					fmt.Println(out)
 					jc.SendString(out)
-					results <- hash
+					results <- fetched{path: fpath, hash: hash}
 					break
 				}
-				defer localFile.Close()
-
-				password := []byte(enckey)              // Change as per your needs.
-				salt := []byte(path.Join(bucket, hash)) // Change as per your needs.
-				decrypted, err := sio.DecryptReader(obj, sio.Config{
-					// generate a 256 bit long key.
-					Key: argon2.IDKey(password, salt, 1, 64*1024, 4, 32),
-				})
-				if err != nil {
-					out := fmt.Sprintf("[!] %s => %s failed to decrpyt file: %s", hash, fpath, err)
-					fmt.Println(out)
-					jc.SendString(out)
-					if i == 2 {
-						results <- hash
+				var dsize int64
+				for _, key := range blobs {
+					var n int64
+					n, err = fetchblob(be, bucket, enckey, key, packs, localFile)
+					dsize += n
+					if err != nil {
 						break
 					}
-					continue
 				}
-				pr := decompressLZ4(decrypted)
-				dsize, err := io.Copy(localFile, pr)
+				localFile.Close()
 				if err != nil {
-					out := fmt.Sprintf("[!] %s => %s failed to decompress file: %s", hash, fpath, err)
+					out := fmt.Sprintf("[!] %s => %s failed to download: %s", hash, fpath, err)
 					fmt.Println(out)
 					jc.SendString(out)
 					if i == 2 {
-						results <- hash
+						results <- fetched{path: fpath, hash: hash}
 						break
 					}
 					continue
 				}
 				elapsed := time.Since(start).Seconds()
 				var s uint64 = uint64(dsize)
-				if len(hash) == 64 {
-					data, err := ioutil.ReadFile(fpath)
+				if _, _, ok := hashfiles.ParseKey(hash); ok {
+					match, err := verify(fpath, hash)
 					if err != nil {
 						out := fmt.Sprintf("[!] %s => %s failed to download: %s", hash, fpath, err)
 						fmt.Println(out)
 						jc.SendString(out)
-						results <- hash
+						results <- fetched{path: fpath, hash: hash}
 						break
 					}
 
-					digest := sha256.Sum256(data)
-					checksum := hex.EncodeToString(digest[:])
-					if hash != checksum {
+					if !match {
 						out := fmt.Sprintf("[!] %s => %s checksum mismatch!", hash, fpath)
 						fmt.Println(out)
 						jc.SendString(out)
-						results <- hash
+						results <- fetched{path: fpath, hash: hash}
 						break
 
 					}
-					out := fmt.Sprintf("[D][V]\t(%.2fs)\t(%s)    \t%s => %s", elapsed, humanize.Bytes(s), hash[:8], b)
+					out := fmt.Sprintf("[D][V]\t(%.2fs)\t(%s)    \t%s => %s", elapsed, humanize.Bytes(s), shortkey(hash), b)
 					fmt.Println(out)
 					jc.SendString(out)
-					results <- ""
+					results <- fetched{path: fpath}
 					break
 
 				} else {
 					out := fmt.Sprintf("[D][%d]\t(%.2fs)\t(%s)    \t%s => %s", i, elapsed, humanize.Bytes(s), hash, b)
 					fmt.Println(out)
 					jc.SendString(out)
-					results <- ""
+					results <- fetched{path: fpath}
 					break
 				}
 			}
@@ -721,24 +894,76 @@ func downloadfile(bucket string, url string, secure bool, accesskey string, secr
 	}
 }
 
+// fetchblob downloads the object key, decrypts and decompresses it and writes
+// the result to w. Blobs found in packs are read from their pack.
+func fetchblob(be backend.Backend, bucket string, enckey string, key string, packs map[string][]string, w io.Writer) (int64, error) {
+	if location, ok := packs[key]; ok {
+		return fetchpacked(be, bucket, enckey, location, w)
+	}
+	obj, err := be.GetObject(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	defer obj.Close()
+
+	password := []byte(enckey)
+	salt := []byte(path.Join(bucket, key))
+	decrypted, err := sio.DecryptReader(obj, sio.Config{
+		// generate a 256 bit long key.
+		Key: argon2.IDKey(password, salt, 1, 64*1024, 4, 32),
+	})
+	if err != nil {
+		return 0, err
+	}
+	// stop decompressing when writing fails half way
+	decompressed := decompressLZ4(decrypted)
+	defer decompressed.Close()
+	return io.Copy(w, decompressed)
+}
+
 // Upload will upload a map of files with the following format:
 // hash -> filepath
 func Upload(url string, port int, secure bool, accesskey string, secretkey string, enckey string, filelist map[string]string, bucket string) ([]string, error) {
+	be, err := backend.Open(url, accesskey, secretkey, secure)
+	if err != nil {
+		return nil, err
+	}
+	return upload(be, enckey, filelist, bucket)
+}
+
+// blob is the part of a local file that is stored under one hash. A length
+// of -1 stands for the whole file.
+type blob struct {
+	path   string
+	offset int64
+	length int64
+}
+
+func upload(be backend.Backend, enckey string, filelist map[string]string, bucket string) ([]string, error) {
+	bloblist := make(map[string]blob)
+	for hash, filepath := range filelist {
+		bloblist[hash] = blob{path: filepath, length: -1}
+	}
+	return uploadblobs(be, enckey, bloblist, bucket)
+}
+
+// uploadblobs uploads a map of blobs in the format hash -> blob
+func uploadblobs(be backend.Backend, enckey string, bloblist map[string]blob, bucket string) ([]string, error) {
 	// break up map into 5 parts
-	jobs := make(chan map[string]string, MAX)
-	results := make(chan string, len(filelist))
+	jobs := make(chan map[string]blob, MAX)
+	results := make(chan string, len(bloblist))
 
 	// This starts up MAX workers, initially blocked
 	// because there are no jobs yet.
 	for w := 1; w <= MAX; w++ {
-		go uploadfile(bucket, url, secure, accesskey, secretkey, enckey, w, jobs, int32(len(filelist)), results)
+		go uploadfile(be, bucket, enckey, w, jobs, int32(len(bloblist)), results)
 	}
 
 	// Here we send MAX `jobs` and then `close` that
 	// channel to indicate that's all the work we have.
-	for hash, filepath := range filelist {
-		job := make(map[string]string)
-		job[hash] = filepath
+	for hash, b := range bloblist {
+		job := make(map[string]blob)
+		job[hash] = b
 		jobs <- job
 	}
 	close(jobs)
@@ -746,7 +971,7 @@ func Upload(url string, port int, secure bool, accesskey string, secretkey strin
 	var grmsgs []string
 	var failed []string
 	// Finally we collect all the results of the work.
-	for a := 1; a <= len(filelist); a++ {
+	for a := 1; a <= len(bloblist); a++ {
 		grmsgs = append(grmsgs, <-results)
 	}
 	close(results)
@@ -769,18 +994,10 @@ func Upload(url string, port int, secure bool, accesskey string, secretkey strin
 
 }
 
-func uploadfile(bucket string, url string, secure bool, accesskey string, secretkey string, enckey string, id int, jobs <-chan map[string]string, numjobs int32, results chan<- string) {
+func uploadfile(be backend.Backend, bucket string, enckey string, id int, jobs <-chan map[string]blob, numjobs int32, results chan<- string) {
 	for j := range jobs {
-		for hash, filepath := range j {
-			s3Client, err := minio.New(url, accesskey, secretkey, secure)
-			// break unrecoverable errors
-			if err != nil {
-				out := fmt.Sprintf("[F] %s => %s failed to upload: %s", hash, filepath, err)
-				fmt.Println(out)
-				jc.SendString(out)
-				results <- hash
-				break
-			}
+		for hash, blob := range j {
+			filepath := blob.path
 			b := path.Base(filepath)
 			for i := 0; i < 3; i++ {
 				// minio-go example code modified:
@@ -792,15 +1009,22 @@ func uploadfile(bucket string, url string, secure bool, accesskey string, secret
 					results <- hash
 					break
 				}
-				defer object.Close()
 				objectStat, err := object.Stat()
 				if err != nil {
+					object.Close()
 					out := fmt.Sprintf("[F] %s => %s failed to upload: %s", hash, filepath, err)
 					fmt.Println(out)
 					jc.SendString(out)
 					results <- hash
 					break
 				}
+				// chunks only upload their section of the file
+				var src io.Reader = object
+				length := objectStat.Size()
+				if blob.length >= 0 {
+					src = io.NewSectionReader(object, blob.offset, blob.length)
+					length = blob.length
+				}
 				password := []byte(enckey)
 				salt := []byte(path.Join(bucket, hash))
 
@@ -808,12 +1032,14 @@ func uploadfile(bucket string, url string, secure bool, accesskey string, secret
 				// try multiple times
 				start := time.Now()
 
-				pw := compressLZ4(object)
+				pw := compressLZ4(src)
 				encrypted, err := sio.EncryptReader(pw, sio.Config{
 					// generate a 256 bit long key.
 					Key: argon2.IDKey(password, salt, 1, 64*1024, 4, 32),
 				})
 				if err != nil {
+					pw.Close()
+					object.Close()
 					out := fmt.Sprintf("[F] %s => %s failed to upload: %s", hash, filepath, err)
 					fmt.Println(out)
 					jc.SendString(out)
@@ -822,8 +1048,10 @@ func uploadfile(bucket string, url string, secure bool, accesskey string, secret
 				}
 
 				// specify size as -1 as there is no way to determine the size
-				size, err := s3Client.PutObject(bucket, hash, encrypted, -1, minio.PutObjectOptions{})
-				if size == 0 && objectStat.Size() != 0 {
+				size, err := be.PutObject(bucket, hash, encrypted, -1)
+				pw.Close()
+				object.Close()
+				if size == 0 && length != 0 {
 					out := fmt.Sprintf("[F] %s => %s failed to upload: %s", hash, filepath, err)
 					fmt.Println(out)
 					jc.SendString(out)
@@ -841,9 +1069,9 @@ func uploadfile(bucket string, url string, secure bool, accesskey string, secret
 					}
 				} else {
 					var s uint64 = uint64(size)
-					if len(hash) == 64 {
-						fmt.Printf("[U][%d]\t(%.2fs)\t(%s)    \t%s => %s\n", i, elapsed, humanize.Bytes(s), hash[:8], b)
-						jc.SendString(fmt.Sprintf("[U][%d]\t(%.2fs)\t(%s)    \t%s => %s\n", i, elapsed, humanize.Bytes(s), hash[:8], b))
+					if _, _, ok := hashfiles.ParseKey(hash); ok {
+						fmt.Printf("[U][%d]\t(%.2fs)\t(%s)    \t%s => %s\n", i, elapsed, humanize.Bytes(s), shortkey(hash), b)
+						jc.SendString(fmt.Sprintf("[U][%d]\t(%.2fs)\t(%s)    \t%s => %s\n", i, elapsed, humanize.Bytes(s), shortkey(hash), b))
 
 					} else {
 						fmt.Printf("[U][%d]\t(%.2fs)\t(%s)    \t%s => %s\n", i, elapsed, humanize.Bytes(s), hash, b)
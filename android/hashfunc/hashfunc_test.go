@@ -0,0 +1,293 @@
+package hashfunc
+
+import (
+	"hashtree-mobile/backend"
+	"hashtree-mobile/fakes3"
+	"hashtree-mobile/hashfiles"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testlog sends the progress of hashfunc to the test log.
+type testlog struct {
+	t *testing.T
+}
+
+func (l testlog) SendString(s string) {
+	l.t.Log(strings.TrimRight(s, "\n"))
+}
+
+// randombytes returns n bytes that are the same on every run for seed.
+func randombytes(seed int64, n int) string {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return string(b)
+}
+
+// testfiles is a tree with packed, whole and chunked files, an empty file
+// and files with the same contents.
+var testfiles = map[string]string{
+	"a.txt":              "hello",
+	"notes/todo.txt":     "buy milk",
+	"notes/copy.txt":     "hello",
+	"notes/empty":        "",
+	"DCIM/2018/img1.jpg": randombytes(1, 300*1024),
+	"DCIM/2018/img2.jpg": randombytes(2, 64*1024),
+	"DCIM/video.mp4":     randombytes(3, 9*1024*1024),
+}
+
+// writetree writes files below dir.
+func writetree(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readtree returns the files below dir by slash separated path, leaving out
+// the files hashfunc keeps next to the tree of bucket.
+func readtree(t *testing.T, dir string, bucket string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), "."+bucket+".") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// sametree fails the test unless the files below dir are files, byte for
+// byte.
+func sametree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	got := readtree(t, dir, "test")
+	for name, data := range files {
+		if _, ok := got[name]; !ok {
+			t.Errorf("%s is missing", name)
+		} else if got[name] != data {
+			t.Errorf("%s differs, %d bytes instead of %d", name, len(got[name]), len(data))
+		}
+	}
+	for name := range got {
+		if _, ok := files[name]; !ok {
+			t.Errorf("%s shouldn't be there", name)
+		}
+	}
+}
+
+// lastsnapshot returns the newest snapshot in the output of Hashlist.
+func lastsnapshot(t *testing.T, list string) string {
+	var last string
+	for _, name := range strings.Split(list, "\n") {
+		if strings.HasSuffix(name, ".hsh") {
+			last = name
+		}
+	}
+	if last == "" {
+		t.Fatalf("no snapshot in %q", list)
+	}
+	return last
+}
+
+// testroundtrip pushes a tree to server and restores it, with and without
+// nuke.
+func testroundtrip(t *testing.T, server string, accesskey string, secretkey string) {
+	RegisterJavaCallback(testlog{t})
+	tmp, err := ioutil.TempDir("", "hashfunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "src")
+	dst := filepath.Join(tmp, "dst")
+	writetree(t, src, testfiles)
+
+	if !Initrepo(server, false, accesskey, secretkey, "password", "test", src) {
+		t.Fatal("Initrepo failed")
+	}
+	if !Hashtree(server, accesskey, secretkey, "password", "test", false, src) {
+		t.Fatal("Hashtree failed")
+	}
+	sametree(t, src, testfiles)
+	snapshot := lastsnapshot(t, Hashlist(server, false, accesskey, secretkey, "test"))
+
+	if !Hashseed(server, accesskey, secretkey, "password", snapshot, "test", false, dst, false) {
+		t.Fatal("Hashseed failed")
+	}
+	sametree(t, dst, testfiles)
+
+	// a file changed since is left alone without nuke and replaced with it
+	changed := make(map[string]string)
+	for name, data := range testfiles {
+		changed[name] = data
+	}
+	changed["notes/todo.txt"] = "buy bread"
+	writetree(t, dst, changed)
+	if Hashseed(server, accesskey, secretkey, "password", snapshot, "test", false, dst, false) {
+		t.Fatal("Hashseed replaced a changed file without nuke")
+	}
+	sametree(t, dst, changed)
+	if !Hashseed(server, accesskey, secretkey, "password", snapshot, "test", false, dst, true) {
+		t.Fatal("Hashseed with nuke failed")
+	}
+	sametree(t, dst, testfiles)
+}
+
+func TestRoundTripLocal(t *testing.T) {
+	repo, err := ioutil.TempDir("", "hashfunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	testroundtrip(t, "file://"+repo, "", "")
+}
+
+func TestRoundTripS3(t *testing.T) {
+	srv := fakes3.NewServer()
+	defer srv.Close()
+	testroundtrip(t, srv.Endpoint(), "access", "secret")
+}
+
+// TestSeedLeavesChangedFiles checks that a file Hashseed declines to replace
+// keeps its own metadata as well as its contents.
+func TestSeedLeavesChangedFiles(t *testing.T) {
+	RegisterJavaCallback(testlog{t})
+	tmp, err := ioutil.TempDir("", "hashfunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	repo := "file://" + filepath.Join(tmp, "repo")
+	src := filepath.Join(tmp, "src")
+	dst := filepath.Join(tmp, "dst")
+	writetree(t, src, map[string]string{"notes/todo.txt": "buy milk", "a.txt": "hello"})
+	then := time.Date(2010, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chmod(filepath.Join(src, "notes/todo.txt"), 0600)
+	os.Chtimes(filepath.Join(src, "notes/todo.txt"), then, then)
+	if !Initrepo(repo, false, "", "", "password", "test", src) || !Hashtree(repo, "", "", "password", "test", false, src) {
+		t.Fatal("push failed")
+	}
+	snapshot := lastsnapshot(t, Hashlist(repo, false, "", "", "test"))
+
+	// the file is there with other contents and metadata
+	writetree(t, dst, map[string]string{"notes/todo.txt": "buy bread"})
+	todo := filepath.Join(dst, "notes/todo.txt")
+	os.Chmod(todo, 0644)
+	before, err := os.Stat(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Hashseed(repo, "", "", "password", snapshot, "test", false, dst, false) {
+		t.Fatal("Hashseed replaced a changed file without nuke")
+	}
+	after, err := os.Stat(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Mode() != before.Mode() || !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("metadata of a file left alone changed from %v %v to %v %v", before.Mode(), before.ModTime(), after.Mode(), after.ModTime())
+	}
+	sametree(t, dst, map[string]string{"notes/todo.txt": "buy bread", "a.txt": "hello"})
+
+	// with nuke it is restored along with its metadata
+	if !Hashseed(repo, "", "", "password", snapshot, "test", false, dst, true) {
+		t.Fatal("Hashseed with nuke failed")
+	}
+	after, err = os.Stat(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(then) {
+		t.Errorf("restored file has time %v", after.ModTime())
+	}
+	// windows only keeps whether a file is read only
+	if runtime.GOOS != "windows" && after.Mode().Perm() != 0600 {
+		t.Errorf("restored file has mode %v", after.Mode())
+	}
+}
+
+// TestParanoid checks that a file rewritten without its size, mtime or inode
+// changing is only picked up by a push in paranoid mode.
+func TestParanoid(t *testing.T) {
+	RegisterJavaCallback(testlog{t})
+	defer SetParanoid(false)
+	tmp, err := ioutil.TempDir("", "hashfunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	repo := "file://" + filepath.Join(tmp, "repo")
+	src := filepath.Join(tmp, "src")
+	todo := filepath.Join(src, "todo.txt")
+	then := time.Now().Add(-time.Hour)
+	writetree(t, src, map[string]string{"todo.txt": "buy milk"})
+	os.Chtimes(todo, then, then)
+	if !Initrepo(repo, false, "", "", "password", "test", src) {
+		t.Fatal("Initrepo failed")
+	}
+	be, err := backend.Open(repo, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pushed returns the key todo.txt has in a new snapshot
+	pushed := func() string {
+		if !Hashtree(repo, "", "", "password", "test", false, src) {
+			t.Fatal("Hashtree failed")
+		}
+		snapshot, err := fetchdb(be, "password", "test", lastsnapshot(t, Hashlist(repo, false, "", "", "test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, paths := range snapshot {
+			for _, p := range paths {
+				if p == "todo.txt" {
+					return key
+				}
+			}
+		}
+		t.Fatal("todo.txt isn't in the snapshot")
+		return ""
+	}
+	milk := pushed()
+
+	// rewritten in place, so the inode stays the same
+	if err := ioutil.WriteFile(todo, []byte("buy beer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(todo, then, then)
+	beer, err := hashfiles.HashFile(todo, hashfiles.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := pushed(); key != milk {
+		t.Errorf("the cache wasn't used, todo.txt is %s", key)
+	}
+	SetParanoid(true)
+	if key := pushed(); key != beer {
+		t.Errorf("todo.txt is %s in paranoid mode, want %s", key, beer)
+	}
+}
@@ -0,0 +1,117 @@
+package hashfunc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hashtree-mobile/backend"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pushes and garbage collection lock the repository so garbage collection
+// never removes content a push relies on. A lock is an object
+// <bucket>.lock-<kind>-<id> holding the device and process that took it.
+// Pushes take shared locks, any number of them can run at once, garbage
+// collection takes an exclusive one. Each lock is created first and the
+// others are looked at after, so of two conflicting locks taken at the same
+// time at least one backs off. A lock is written again every lockRefresh
+// while it is held, so a push that runs for days keeps its lock. Locks left
+// behind by a crash are ignored once they haven't been written for
+// staleLock.
+
+const (
+	sharedLock    = "shared"
+	exclusiveLock = "exclusive"
+	staleLock     = 24 * time.Hour
+)
+
+// lockRefresh is how often a held lock is written again. It is much shorter
+// than staleLock so a few failed refreshes don't let the lock go stale.
+var lockRefresh = 10 * time.Minute
+
+// ErrLocked is returned when the repository is locked by someone else.
+var ErrLocked = errors.New("repository is locked")
+
+// repolock is a lock held on a repository.
+type repolock struct {
+	be     backend.Backend
+	bucket string
+	name   string
+	// stop ends the refreshing of the lock, which closes done once it
+	// won't write the lock again.
+	stop chan struct{}
+	done chan struct{}
+}
+
+// lockrepo locks the repository in bucket, exclusively if exclusive is set.
+func lockrepo(be backend.Backend, enckey string, bucket string, exclusive bool) (*repolock, error) {
+	kind := sharedLock
+	if exclusive {
+		kind = exclusiveLock
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	lock := &repolock{
+		be:     be,
+		bucket: bucket,
+		name:   bucket + ".lock-" + kind + "-" + hex.EncodeToString(id),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	pid := strconv.Itoa(os.Getpid())
+	db := map[string][]string{"host": {devicename()}, "pid": {pid}}
+	err := savedb(be, enckey, bucket, lock.name, db)
+	if err != nil {
+		return nil, err
+	}
+	var held []string
+	err = be.ListObjects(bucket, bucket+".lock-", func(object backend.ObjectInfo) error {
+		if object.Key == lock.name || time.Since(object.LastModified) > staleLock {
+			return nil
+		}
+		if exclusive || strings.HasPrefix(object.Key, bucket+".lock-"+exclusiveLock+"-") {
+			held = append(held, object.Key)
+		}
+		return nil
+	})
+	if err == nil && len(held) > 0 {
+		err = fmt.Errorf("%v by %s", ErrLocked, strings.Join(held, ", "))
+	}
+	if err != nil {
+		close(lock.done)
+		lock.unlock()
+		return nil, err
+	}
+	go lock.refresh(enckey, db)
+	return lock, nil
+}
+
+// refresh writes the lock again every lockRefresh until it is released.
+func (l *repolock) refresh(enckey string, db map[string][]string) {
+	defer close(l.done)
+	ticker := time.NewTicker(lockRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := savedb(l.be, enckey, l.bucket, l.name, db); err != nil {
+				jc.SendString(fmt.Sprint("Unable to refresh lock ", l.name, ": ", err))
+			}
+		}
+	}
+}
+
+// unlock releases the lock.
+func (l *repolock) unlock() error {
+	close(l.stop)
+	<-l.done
+	return l.be.RemoveObject(l.bucket, l.name)
+}
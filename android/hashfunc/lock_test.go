@@ -0,0 +1,79 @@
+package hashfunc
+
+import (
+	"hashtree-mobile/backend"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// locks returns the locks held on bucket by name and when they were last
+// written.
+func locks(t *testing.T, be backend.Backend, bucket string) map[string]time.Time {
+	held := make(map[string]time.Time)
+	err := be.ListObjects(bucket, bucket+".lock-", func(object backend.ObjectInfo) error {
+		held[object.Key] = object.LastModified
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return held
+}
+
+func TestLock(t *testing.T) {
+	RegisterJavaCallback(testlog{t})
+	defer func(d time.Duration) { lockRefresh = d }(lockRefresh)
+	lockRefresh = 50 * time.Millisecond
+	dir, err := ioutil.TempDir("", "hashfunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	be, err := backend.Open("file://"+dir, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := be.MakeBucket("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	push, err := lockrepo(be, "password", "test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := lockrepo(be, "password", "test", false)
+	if err != nil {
+		t.Fatalf("shared locks conflict: %v", err)
+	}
+	other.unlock()
+	if _, err := lockrepo(be, "password", "test", true); err == nil || !strings.Contains(err.Error(), ErrLocked.Error()) {
+		t.Fatalf("exclusive lock taken during a push: %v", err)
+	}
+
+	// a push keeps its lock fresh for as long as it runs
+	taken := locks(t, be, "test")[push.name]
+	time.Sleep(1100 * time.Millisecond)
+	if refreshed := locks(t, be, "test")[push.name]; !refreshed.After(taken) {
+		t.Errorf("lock written at %v wasn't refreshed by %v", taken, refreshed)
+	}
+
+	// and once released it isn't written again
+	if err := push.unlock(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * lockRefresh)
+	if held := locks(t, be, "test"); len(held) != 0 {
+		t.Fatalf("locks left behind: %v", held)
+	}
+	gc, err := lockrepo(be, "password", "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockrepo(be, "password", "test", false); err == nil {
+		t.Fatal("shared lock taken during garbage collection")
+	}
+	gc.unlock()
+}
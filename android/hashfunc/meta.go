@@ -0,0 +1,353 @@
+package hashfunc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hashtree-mobile/backend"
+	"hashtree-mobile/hashfiles"
+	"hashtree-mobile/merkle"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every snapshot <bucket>-<time>.hsh has a metadata file next to it,
+// <bucket>-<time>.meta, in the format key => [ values ]:
+//
+//	hash => [ algorithm the directories of the snapshot are hashed with ]
+//	root => [ root hash of the snapshot ]
+//	ignore => [ ignore rules in effect when the snapshot was made ]
+//	host => [ device the snapshot was made on ]
+//	dir => [ directory the snapshot was made of ]
+//	start => [ time the scan started, RFC 3339 ]
+//	end => [ time the snapshot was written, RFC 3339 ]
+//	files => [ number of files in the snapshot ]
+//	bytes => [ total size of the files ]
+//	uploaded => [ bytes of new content uploaded for it ]
+//	version => [ version of hashtree that made it ]
+//	parent => [ previous snapshot of the same host and directory ]
+//	tag => [ tags set with SetTags ]
+//
+// Snapshots made before metadata was recorded have none, those made before
+// the description of the snapshot was recorded have only the first three.
+
+// Version is the version of hashtree recorded in the snapshots it makes.
+const Version = "1.0.8"
+
+// hostname names the device in the snapshots it makes, the host name of the
+// system if it is empty.
+var hostname string
+
+// SetHostname sets the name snapshots record the device they were made on
+// with. Android reports the same host name for every phone, so the app
+// should pass something that tells its devices apart.
+func SetHostname(name string) {
+	hostname = name
+}
+
+// devicename returns the name of the device snapshots are made on.
+func devicename() string {
+	if hostname != "" {
+		return hostname
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// tags are recorded in the snapshots Hashtree makes.
+var tags []string
+
+// SetTags sets the tags, separated by commas, recorded in the snapshots
+// Hashtree makes from now on.
+func SetTags(list string) {
+	tags = nil
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+}
+
+// snapshotinfo describes a snapshot, as recorded in its metadata.
+type snapshotinfo struct {
+	Name     string    `json:"name"`
+	Root     string    `json:"root,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	Dir      string    `json:"dir,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Files    int64     `json:"files"`
+	Bytes    int64     `json:"bytes"`
+	Uploaded int64     `json:"uploaded"`
+	Version  string    `json:"version,omitempty"`
+	Parent   string    `json:"parent,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+// metaname returns the name of the metadata file of the snapshot name.
+func metaname(snapshot string) string {
+	return strings.TrimSuffix(snapshot, ".hsh") + ".meta"
+}
+
+// snapshotmeta returns the metadata of a snapshot described by info whose
+// tree is root and that was scanned with rules.
+func snapshotmeta(root *merkle.Node, alg hashfiles.Algorithm, rules []*hashfiles.Rule, info *snapshotinfo) map[string][]string {
+	meta := make(map[string][]string)
+	meta["hash"] = []string{string(alg)}
+	meta["root"] = []string{root.Hash}
+	for _, rule := range rules {
+		meta["ignore"] = append(meta["ignore"], rule.String())
+	}
+	meta["host"] = []string{info.Host}
+	meta["dir"] = []string{info.Dir}
+	meta["start"] = []string{info.Start.Format(time.RFC3339)}
+	meta["end"] = []string{info.End.Format(time.RFC3339)}
+	meta["files"] = []string{strconv.FormatInt(info.Files, 10)}
+	meta["bytes"] = []string{strconv.FormatInt(info.Bytes, 10)}
+	meta["uploaded"] = []string{strconv.FormatInt(info.Uploaded, 10)}
+	meta["version"] = []string{info.Version}
+	if info.Parent != "" {
+		meta["parent"] = []string{info.Parent}
+	}
+	if len(info.Tags) > 0 {
+		meta["tag"] = info.Tags
+	}
+	return meta
+}
+
+// parseinfo returns the description of the snapshot name recorded in meta.
+// Snapshots that don't record when they were made are taken to have been
+// made at the time in their name.
+func parseinfo(name string, meta map[string][]string) *snapshotinfo {
+	first := func(key string) string {
+		if len(meta[key]) > 0 {
+			return meta[key][0]
+		}
+		return ""
+	}
+	number := func(key string) int64 {
+		n, _ := strconv.ParseInt(first(key), 10, 64)
+		return n
+	}
+	info := &snapshotinfo{
+		Name:     name,
+		Root:     first("root"),
+		Host:     first("host"),
+		Dir:      first("dir"),
+		Files:    number("files"),
+		Bytes:    number("bytes"),
+		Uploaded: number("uploaded"),
+		Version:  first("version"),
+		Parent:   first("parent"),
+		Tags:     meta["tag"],
+	}
+	info.End, _ = time.Parse(time.RFC3339, first("end"))
+	if info.End.IsZero() {
+		info.End = snapshottime(name)
+	}
+	info.Start, _ = time.Parse(time.RFC3339, first("start"))
+	if info.Start.IsZero() {
+		info.Start = info.End
+	}
+	return info
+}
+
+// snapshottime returns the time in the name of the snapshot name, the zero
+// time if there is none.
+func snapshottime(name string) time.Time {
+	name = strings.TrimSuffix(name, ".hsh")
+	layout := "2006-01-02_15:04:05"
+	if len(name) < len(layout) {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation(layout, name[len(name)-len(layout):], time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// listsnapshots returns the names of the snapshots in bucket, oldest first.
+func listsnapshots(be backend.Backend, bucket string) ([]string, error) {
+	var snapshots []string
+	err := be.ListObjects(bucket, bucket+"-", func(object backend.ObjectInfo) error {
+		if strings.HasSuffix(object.Key, ".hsh") {
+			snapshots = append(snapshots, object.Key)
+		}
+		return nil
+	})
+	// the time in the names sorts in order
+	sort.Strings(snapshots)
+	return snapshots, err
+}
+
+// snapshotinfos returns the descriptions of the snapshots in bucket, oldest
+// first.
+func snapshotinfos(be backend.Backend, enckey string, bucket string) ([]*snapshotinfo, error) {
+	snapshots, err := listsnapshots(be, bucket)
+	if err != nil {
+		return nil, err
+	}
+	var infos []*snapshotinfo
+	for _, name := range snapshots {
+		meta, err := loaddb(be, enckey, bucket, metaname(name))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, parseinfo(name, meta))
+	}
+	return infos, nil
+}
+
+// findparent returns the newest snapshot in bucket of dir on host, "" if
+// there is none.
+func findparent(be backend.Backend, enckey string, bucket string, host string, dir string) (string, error) {
+	snapshots, err := listsnapshots(be, bucket)
+	if err != nil {
+		return "", err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		meta, err := loaddb(be, enckey, bucket, metaname(snapshots[i]))
+		if err != nil {
+			return "", err
+		}
+		info := parseinfo(snapshots[i], meta)
+		if info.Host == host && info.Dir == dir {
+			return snapshots[i], nil
+		}
+	}
+	return "", nil
+}
+
+// Hashinfo returns the descriptions of the snapshots in bucketname, oldest
+// first, as a JSON array of objects with the fields name, root, host, dir,
+// start, end, files, bytes, uploaded, version, parent and tags. It returns
+// "ERROR" if they can't be read.
+func Hashinfo(server string, accesskey string, secretkey string, enckey string, bucketname string, secure bool) string {
+	be, err := backend.Open(server, accesskey, secretkey, secure)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return hashinfo(be, enckey, bucketname)
+}
+
+func hashinfo(be backend.Backend, enckey string, bucketname string) string {
+	infos, err := snapshotinfos(be, enckey, bucketname)
+	if err != nil {
+		jc.SendString(fmt.Sprintln("Error unable to read snapshots!", err))
+		return "ERROR"
+	}
+	if infos == nil {
+		infos = []*snapshotinfo{}
+	}
+	out, err := json.Marshal(infos)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return string(out)
+}
+
+// buildtree returns the tree of a snapshot along with the directories, links
+// and named pipes recorded in its file metadata. A link is hashed by its
+// target.
+func buildtree(snapshot map[string][]string, attrdb map[string][]string, alg hashfiles.Algorithm) *merkle.Node {
+	root := merkle.New()
+	for hash, paths := range snapshot {
+		for _, p := range paths {
+			root.Add(p, &merkle.Node{Hash: hash})
+		}
+	}
+	for p, fields := range attrdb {
+		switch kind := attrtype(fields); kind {
+		case "":
+		case hashfiles.TypeDir:
+			root.Add(p, merkle.New())
+		default:
+			h := alg.New()
+			if attr, err := hashfiles.ParseAttr(fields); err == nil {
+				h.Write([]byte(attr.Target))
+			}
+			root.Add(p, &merkle.Node{Hash: alg.Key(h.Sum(nil)), Kind: kind})
+		}
+	}
+	root.Rehash(alg)
+	return root
+}
+
+// checkroot builds the tree of snapshot and checks it against the root hash
+// recorded in its metadata. Snapshots without a recorded root hash are
+// hashed with sha256 and pass.
+func checkroot(snapshot map[string][]string, attrdb map[string][]string, meta map[string][]string) (*merkle.Node, error) {
+	var name string
+	if len(meta["hash"]) > 0 {
+		name = meta["hash"][0]
+	}
+	alg, err := hashfiles.ParseAlgorithm(name)
+	if err != nil {
+		return nil, err
+	}
+	root := buildtree(snapshot, attrdb, alg)
+	if len(meta["root"]) > 0 && meta["root"][0] != root.Hash {
+		return root, errors.New(fmt.Sprint("snapshot doesn't match its root hash ", meta["root"][0]))
+	}
+	return root, nil
+}
+
+// loadtree reads the snapshot name and returns its tree, checked against
+// its root hash, along with the metadata of its files.
+func loadtree(be backend.Backend, enckey string, bucket string, name string) (*merkle.Node, map[string][]string, error) {
+	snapshot, err := fetchdb(be, enckey, bucket, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := loaddb(be, enckey, bucket, metaname(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	attrdb, err := loaddb(be, enckey, bucket, attrname(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := checkroot(snapshot, attrdb, meta)
+	return root, attrdb, err
+}
+
+// Hashroot returns the root hash of a snapshot after checking the snapshot
+// still matches it, or "ERROR".
+func Hashroot(server string, accesskey string, secretkey string, enckey string, databasename string, bucketname string, secure bool) string {
+	be, err := backend.Open(server, accesskey, secretkey, secure)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return hashroot(be, enckey, databasename, bucketname)
+}
+
+func hashroot(be backend.Backend, enckey string, databasename string, bucketname string) string {
+	snapshot, err := fetchdb(be, enckey, bucketname, databasename)
+	if err != nil {
+		jc.SendString(fmt.Sprintln("Error unable to download database!", err))
+		return "ERROR"
+	}
+	meta, err := loaddb(be, enckey, bucketname, metaname(databasename))
+	if err != nil {
+		jc.SendString(fmt.Sprintln("Error unable to download snapshot metadata!", err))
+		return "ERROR"
+	}
+	attrdb, err := loaddb(be, enckey, bucketname, attrname(databasename))
+	if err != nil {
+		jc.SendString(fmt.Sprintln("Error unable to download file metadata!", err))
+		return "ERROR"
+	}
+	root, err := checkroot(snapshot, attrdb, meta)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return "ERROR"
+	}
+	return root.Hash
+}
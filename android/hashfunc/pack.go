@@ -0,0 +1,250 @@
+package hashfunc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hashtree-mobile/backend"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/minio/sio"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// blobs smaller than packThreshold are grouped into pack objects
+	// instead of being stored as an object each.
+	packThreshold = 256 * 1024
+	// packSize is the amount of data collected before a pack is closed.
+	packSize = 4 * 1024 * 1024
+)
+
+// Pack objects hold many small blobs, each compressed and encrypted on its
+// own so it can be read back with a range request. All blobs of a pack share
+// one key, derived from the name of the pack. The pack index maps the hash
+// of every packed blob to [ pack, offset, length ].
+
+// packkeys caches the keys of packs and databases, so restoring many blobs
+// from one pack doesn't derive the same key over and over.
+var packkeys = struct {
+	sync.Mutex
+	m map[string][]byte
+}{m: make(map[string][]byte)}
+
+// packkey returns the key of the pack or database name.
+func packkey(enckey string, bucket string, name string) []byte {
+	id := enckey + "\x00" + path.Join(bucket, name)
+	packkeys.Lock()
+	key, ok := packkeys.m[id]
+	packkeys.Unlock()
+	if ok {
+		return key
+	}
+	key = argon2.IDKey([]byte(enckey), []byte(path.Join(bucket, name)), 1, 64*1024, 4, 32)
+	packkeys.Lock()
+	packkeys.m[id] = key
+	packkeys.Unlock()
+	return key
+}
+
+// planpacks moves the small blobs out of bloblist and groups them into packs.
+// Each pack is a list of blob hashes, the blobs themselves are returned in
+// packed.
+func planpacks(bloblist map[string]blob) ([][]string, map[string]blob) {
+	var small []string
+	sizes := make(map[string]int64)
+	for hash, b := range bloblist {
+		size := b.length
+		if size < 0 {
+			info, err := os.Stat(b.path)
+			if err != nil {
+				continue
+			}
+			size = info.Size()
+		}
+		if size < packThreshold {
+			small = append(small, hash)
+			sizes[hash] = size
+		}
+	}
+	sort.Strings(small)
+
+	var packs [][]string
+	var pack []string
+	var total int64
+	for _, hash := range small {
+		pack = append(pack, hash)
+		total += sizes[hash]
+		if total >= packSize {
+			packs = append(packs, pack)
+			pack = nil
+			total = 0
+		}
+	}
+	if len(pack) > 0 {
+		packs = append(packs, pack)
+	}
+	packed := make(map[string]blob)
+	for _, hash := range small {
+		packed[hash] = bloblist[hash]
+		delete(bloblist, hash)
+	}
+	return packs, packed
+}
+
+// packname names a pack after the blobs it holds.
+func packname(pack []string) string {
+	digest := sha256.Sum256([]byte(strings.Join(pack, "\n")))
+	return "pack-" + hex.EncodeToString(digest[:])
+}
+
+// uploadpacks uploads packs of blobs from bloblist and adds their location to
+// packdb. It returns the hashes of the blobs that failed to upload.
+func uploadpacks(be backend.Backend, enckey string, bloblist map[string]blob, packs [][]string, bucket string, packdb map[string][]string) ([]string, error) {
+	jobs := make(chan []string, MAX)
+	results := make(chan map[string][]string, len(packs))
+
+	// This starts up MAX workers, initially blocked
+	// because there are no jobs yet.
+	for w := 1; w <= MAX; w++ {
+		go uploadpack(be, bucket, enckey, bloblist, jobs, results)
+	}
+	for _, pack := range packs {
+		jobs <- pack
+	}
+	close(jobs)
+
+	var failed []string
+	for a := 1; a <= len(packs); a++ {
+		index := <-results
+		for hash, location := range index {
+			if location == nil {
+				failed = append(failed, hash)
+			} else {
+				packdb[hash] = location
+			}
+		}
+	}
+	close(results)
+	if len(failed) != 0 {
+		out := fmt.Sprintf("Failed to upload: %v packed files", len(failed))
+		fmt.Println(out)
+		return failed, New(out)
+	}
+	return failed, nil
+}
+
+// uploadpack builds each pack it receives in memory and uploads it. The
+// result maps each blob of the pack to its location, or nil if it failed.
+func uploadpack(be backend.Backend, bucket string, enckey string, bloblist map[string]blob, jobs <-chan []string, results chan<- map[string][]string) {
+	for pack := range jobs {
+		name := packname(pack)
+		key := packkey(enckey, bucket, name)
+		index := make(map[string][]string)
+		fail := func(err error) {
+			out := fmt.Sprintf("[F] %s failed to upload: %s", name[:13], err)
+			fmt.Println(out)
+			jc.SendString(out)
+			for _, hash := range pack {
+				index[hash] = nil
+			}
+			results <- index
+		}
+
+		var buf bytes.Buffer
+		var err error
+		for _, hash := range pack {
+			offset := buf.Len()
+			err = packblob(&buf, bloblist[hash], key)
+			if err != nil {
+				break
+			}
+			index[hash] = []string{name, strconv.Itoa(offset), strconv.Itoa(buf.Len() - offset)}
+		}
+		if err != nil {
+			fail(err)
+			continue
+		}
+
+		// try multiple times
+		for i := 0; i < 3; i++ {
+			start := time.Now()
+			_, err = be.PutObject(bucket, name, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err == nil {
+				elapsed := time.Since(start).Seconds()
+				out := fmt.Sprintf("[U][%d]\t(%.2fs)\t(%s)    \t%s => %d files", i, elapsed, humanize.Bytes(uint64(buf.Len())), name[:13], len(pack))
+				fmt.Println(out)
+				jc.SendString(out)
+				break
+			}
+			time.Sleep(time.Duration(i) * time.Second)
+		}
+		if err != nil {
+			fail(err)
+			continue
+		}
+		results <- index
+	}
+}
+
+// packblob compresses and encrypts b and appends it to buf.
+func packblob(buf *bytes.Buffer, b blob, key []byte) error {
+	file, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var src io.Reader = file
+	if b.length >= 0 {
+		src = io.NewSectionReader(file, b.offset, b.length)
+	}
+	compressed := compressLZ4(src)
+	defer compressed.Close()
+	encrypted, err := sio.EncryptReader(compressed, sio.Config{Key: key})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(buf, encrypted)
+	return err
+}
+
+// fetchpacked reads the blob at location out of its pack, decrypts and
+// decompresses it and writes the result to w.
+func fetchpacked(be backend.Backend, bucket string, enckey string, location []string, w io.Writer) (int64, error) {
+	if len(location) != 3 {
+		return 0, fmt.Errorf("invalid pack index entry %v", location)
+	}
+	offset, err := strconv.ParseInt(location[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	length, err := strconv.ParseInt(location[2], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if length == 0 {
+		return 0, nil
+	}
+	obj, err := be.GetObjectRange(bucket, location[0], offset, length)
+	if err != nil {
+		return 0, err
+	}
+	defer obj.Close()
+	decrypted, err := sio.DecryptReader(obj, sio.Config{Key: packkey(enckey, bucket, location[0])})
+	if err != nil {
+		return 0, err
+	}
+	// stop decompressing when writing fails half way
+	decompressed := decompressLZ4(decrypted)
+	defer decompressed.Close()
+	return io.Copy(w, decompressed)
+}
@@ -0,0 +1,83 @@
+package hashfunc
+
+import (
+	"fmt"
+	"hashtree-mobile/backend"
+	"hashtree-mobile/hashfiles"
+	"strings"
+)
+
+// Hashseedpaths restores the files of the snapshot that match paths, like
+// Hashseed restores all of them. paths holds globs, one per line and
+// relative to the root of the snapshot, such as DCIM/2018/** or
+// notes/todo.txt. A directory selects everything in it. The rest of the
+// snapshot is left alone and files already in dir that don't match aren't
+// touched. It returns false if a glob can't be parsed or nothing matches.
+func Hashseedpaths(server string, accesskey string, secretkey string, enckey string, databasename string, bucketname string, secure bool, dir string, nuke bool, paths string) bool {
+	globs, err := parsepaths(paths)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return false
+	}
+	if len(globs) == 0 {
+		jc.SendString("No paths to restore.")
+		return false
+	}
+	be, err := backend.Open(server, accesskey, secretkey, secure)
+	if err != nil {
+		jc.SendString(fmt.Sprintln(err))
+		return false
+	}
+	return hashseed(be, enckey, databasename, bucketname, dir, nuke, globs)
+}
+
+// parsepaths parses globs given one per line, blank lines are skipped.
+func parsepaths(paths string) ([]*hashfiles.Glob, error) {
+	var globs []*hashfiles.Glob
+	for _, line := range strings.Split(paths, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		g, err := hashfiles.ParseGlob(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid restore path %s: %v", line, err)
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+// selectpaths returns the part of the snapshot and its file metadata that
+// matches globs. Hard links to a file that isn't selected are restored as
+// copies of it.
+func selectpaths(snapshot map[string][]string, attrdb map[string][]string, globs []*hashfiles.Glob) (map[string][]string, map[string][]string) {
+	selected := make(map[string][]string)
+	for hash, paths := range snapshot {
+		for _, p := range paths {
+			if hashfiles.MatchAny(globs, p) {
+				selected[hash] = append(selected[hash], p)
+			}
+		}
+	}
+	attrs := make(map[string][]string)
+	for p, fields := range attrdb {
+		if !hashfiles.MatchAny(globs, p) {
+			continue
+		}
+		if link := attrlink(fields); link != "" && !hashfiles.MatchAny(globs, link) {
+			var kept []string
+			for _, field := range fields {
+				if !strings.HasPrefix(field, "link=") {
+					kept = append(kept, field)
+				}
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			fields = kept
+		}
+		attrs[p] = fields
+	}
+	return selected, attrs
+}
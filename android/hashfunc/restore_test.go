@@ -0,0 +1,81 @@
+package hashfunc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeedPaths(t *testing.T) {
+	RegisterJavaCallback(testlog{t})
+	tmp, err := ioutil.TempDir("", "hashfunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	repo := "file://" + filepath.Join(tmp, "repo")
+	src := filepath.Join(tmp, "src")
+	dst := filepath.Join(tmp, "dst")
+	writetree(t, src, testfiles)
+	if err := os.MkdirAll(filepath.Join(src, "DCIM/2018/empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "a.txt"), filepath.Join(src, "notes/hello.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if !Initrepo(repo, false, "", "", "password", "test", src) || !Hashtree(repo, "", "", "password", "test", false, src) {
+		t.Fatal("push failed")
+	}
+	snapshot := lastsnapshot(t, Hashlist(repo, false, "", "", "test"))
+	all := make(map[string]string)
+	for name, data := range testfiles {
+		all[name] = data
+	}
+	all["notes/hello.txt"] = all["a.txt"]
+
+	// files already there that don't match are left alone
+	writetree(t, dst, map[string]string{"DCIM/video.mp4": "local", "mine.txt": "mine"})
+	if !Hashseedpaths(repo, "", "", "password", snapshot, "test", false, dst, true, "DCIM/2018/**\n/notes/todo.txt\n\nnotes/h?llo.txt") {
+		t.Fatal("Hashseedpaths failed")
+	}
+	sametree(t, dst, map[string]string{
+		"DCIM/2018/img1.jpg": all["DCIM/2018/img1.jpg"],
+		"DCIM/2018/img2.jpg": all["DCIM/2018/img2.jpg"],
+		"DCIM/video.mp4":     "local",
+		"notes/todo.txt":     all["notes/todo.txt"],
+		// a hard link to a file that isn't restored is a copy of it
+		"notes/hello.txt": all["a.txt"],
+		"mine.txt":        "mine",
+	})
+	if info, err := os.Stat(filepath.Join(dst, "DCIM/2018/empty")); err != nil || !info.IsDir() {
+		t.Errorf("empty directory wasn't restored: %v", err)
+	}
+
+	// a directory selects what is in it
+	os.RemoveAll(dst)
+	if !Hashseedpaths(repo, "", "", "password", snapshot, "test", false, dst, false, "notes") {
+		t.Fatal("Hashseedpaths of a directory failed")
+	}
+	sametree(t, dst, map[string]string{
+		"notes/todo.txt":  all["notes/todo.txt"],
+		"notes/copy.txt":  all["notes/copy.txt"],
+		"notes/empty":     all["notes/empty"],
+		"notes/hello.txt": all["notes/hello.txt"],
+	})
+
+	// a restore that matches nothing fails
+	if Hashseedpaths(repo, "", "", "password", snapshot, "test", false, dst, false, "nothing/*") {
+		t.Error("Hashseedpaths restored paths that aren't in the snapshot")
+	}
+	if Hashseedpaths(repo, "", "", "password", snapshot, "test", false, dst, false, "\n\n") {
+		t.Error("Hashseedpaths without paths succeeded")
+	}
+
+	// and a full restore after a partial one restores everything, the copy
+	// of the hard link is in the way of the link without nuke
+	if !Hashseed(repo, "", "", "password", snapshot, "test", false, dst, true) {
+		t.Fatal("Hashseed failed")
+	}
+	sametree(t, dst, all)
+}
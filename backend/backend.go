@@ -0,0 +1,75 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package backend
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a bucket or object does not exist.
+var ErrNotFound = errors.New("backend: object not found")
+
+// ObjectInfo describes an object held by a Backend.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Backend is the storage a repository lives in. Objects are addressed by
+// bucket and name, the same way an S3 endpoint addresses them.
+type Backend interface {
+	// BucketExists reports whether bucket has been created.
+	BucketExists(bucket string) (bool, error)
+	// MakeBucket creates bucket.
+	MakeBucket(bucket string) error
+	// PutObject stores the contents of r as name. size may be -1 if the
+	// length is not known in advance. It returns the number of bytes stored.
+	PutObject(bucket string, name string, r io.Reader, size int64) (int64, error)
+	// GetObject opens name for reading. The caller must close it.
+	GetObject(bucket string, name string) (io.ReadCloser, error)
+	// GetObjectRange opens length bytes of name starting at offset for
+	// reading. The caller must close it.
+	GetObjectRange(bucket string, name string, offset int64, length int64) (io.ReadCloser, error)
+	// StatObject returns information about name without reading it.
+	StatObject(bucket string, name string) (ObjectInfo, error)
+	// ListObjects calls fn for every object whose name begins with prefix.
+	// Listing stops at the first error returned by fn.
+	ListObjects(bucket string, prefix string, fn func(ObjectInfo) error) error
+	// RemoveObject deletes name. Removing a missing object is not an error.
+	RemoveObject(bucket string, name string) error
+}
+
+// Open returns the Backend for server. A server of the form file:///path or
+// an absolute path selects a local directory repository, anything else is
+// treated as an S3 compatible endpoint.
+func Open(server string, accesskey string, secretkey string, secure bool) (Backend, error) {
+	if strings.HasPrefix(server, "file://") {
+		return NewLocal(strings.TrimPrefix(server, "file://"))
+	}
+	if filepath.IsAbs(server) {
+		return NewLocal(server)
+	}
+	return NewMinio(server, accesskey, secretkey, secure)
+}
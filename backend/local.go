@@ -0,0 +1,186 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package backend
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localBackend stores objects as plain files below root. Each bucket is a
+// directory and each object a file within it.
+type localBackend struct {
+	root string
+}
+
+// NewLocal returns a Backend that keeps the repository in the directory root,
+// for example a USB drive or a NAS mount.
+func NewLocal(root string) (Backend, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &localBackend{root: root}, nil
+}
+
+// escapeName makes an object name safe to use as a file name on any file
+// system. Snapshot names contain ':' which FAT formatted drives reject.
+func escapeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '.' || c == '-' || c == '_' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func (l *localBackend) bucketDir(bucket string) string {
+	return filepath.Join(l.root, escapeName(bucket))
+}
+
+func (l *localBackend) objectPath(bucket string, name string) string {
+	return filepath.Join(l.bucketDir(bucket), escapeName(name))
+}
+
+func (l *localBackend) BucketExists(bucket string) (bool, error) {
+	info, err := os.Stat(l.bucketDir(bucket))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+func (l *localBackend) MakeBucket(bucket string) error {
+	return os.MkdirAll(l.bucketDir(bucket), 0755)
+}
+
+// PutObject writes to a temporary file in the bucket and renames it into
+// place, so a reader never sees a partially written object.
+func (l *localBackend) PutObject(bucket string, name string, r io.Reader, size int64) (int64, error) {
+	dir := l.bucketDir(bucket)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), l.objectPath(bucket, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return n, err
+	}
+	return n, nil
+}
+
+func (l *localBackend) GetObject(bucket string, name string) (io.ReadCloser, error) {
+	file, err := os.Open(l.objectPath(bucket, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// sectionReadCloser closes the file a section is read from.
+type sectionReadCloser struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (s sectionReadCloser) Close() error {
+	return s.file.Close()
+}
+
+func (l *localBackend) GetObjectRange(bucket string, name string, offset int64, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(l.objectPath(bucket, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sectionReadCloser{io.NewSectionReader(file, offset, length), file}, nil
+}
+
+func (l *localBackend) StatObject(bucket string, name string) (ObjectInfo, error) {
+	info, err := os.Stat(l.objectPath(bucket, name))
+	if os.IsNotExist(err) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: name, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+func (l *localBackend) ListObjects(bucket string, prefix string, fn func(ObjectInfo) error) error {
+	infos, err := ioutil.ReadDir(l.bucketDir(bucket))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") {
+			continue
+		}
+		name, err := url.PathUnescape(info.Name())
+		if err != nil || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		err = fn(ObjectInfo{Key: name, Size: info.Size(), LastModified: info.ModTime()})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *localBackend) RemoveObject(bucket string, name string) error {
+	err := os.Remove(l.objectPath(bucket, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package backend
+
+import (
+	"io"
+
+	"github.com/minio/minio-go"
+)
+
+// minioBackend stores objects on an S3 compatible endpoint.
+type minioBackend struct {
+	client *minio.Client
+}
+
+// NewMinio returns a Backend for an Amazon S3 compatible endpoint. API
+// compatibility (v2 or v4) is automatically determined based on server.
+func NewMinio(server string, accesskey string, secretkey string, secure bool) (Backend, error) {
+	client, err := minio.New(server, accesskey, secretkey, secure)
+	if err != nil {
+		return nil, err
+	}
+	return &minioBackend{client: client}, nil
+}
+
+// notFound translates the S3 "no such" error codes into ErrNotFound.
+func notFound(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return ErrNotFound
+	}
+	return err
+}
+
+func (m *minioBackend) BucketExists(bucket string) (bool, error) {
+	return m.client.BucketExists(bucket)
+}
+
+func (m *minioBackend) MakeBucket(bucket string) error {
+	return m.client.MakeBucket(bucket, "us-east-1")
+}
+
+func (m *minioBackend) PutObject(bucket string, name string, r io.Reader, size int64) (int64, error) {
+	return m.client.PutObject(bucket, name, r, size, minio.PutObjectOptions{})
+}
+
+func (m *minioBackend) GetObject(bucket string, name string) (io.ReadCloser, error) {
+	obj, err := m.client.GetObject(bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, notFound(err)
+	}
+	// GetObject is lazy, stat the object so a missing key is reported here
+	// rather than on the first read.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, notFound(err)
+	}
+	return obj, nil
+}
+
+func (m *minioBackend) GetObjectRange(bucket string, name string, offset int64, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+	// Object.Stat drops the range, use the core API which sends the request
+	// straight away.
+	obj, _, err := minio.Core{Client: m.client}.GetObject(bucket, name, opts)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return obj, nil
+}
+
+func (m *minioBackend) StatObject(bucket string, name string) (ObjectInfo, error) {
+	st, err := m.client.StatObject(bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, notFound(err)
+	}
+	return ObjectInfo{Key: st.Key, Size: st.Size, LastModified: st.LastModified}, nil
+}
+
+func (m *minioBackend) ListObjects(bucket string, prefix string, fn func(ObjectInfo) error) error {
+	// Create a done channel to control 'ListObjects' go routine.
+	doneCh := make(chan struct{})
+	// Indicate to our routine to exit cleanly upon return.
+	defer close(doneCh)
+
+	for object := range m.client.ListObjects(bucket, prefix, true, doneCh) {
+		if object.Err != nil {
+			return notFound(object.Err)
+		}
+		err := fn(ObjectInfo{Key: object.Key, Size: object.Size, LastModified: object.LastModified})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *minioBackend) RemoveObject(bucket string, name string) error {
+	return m.client.RemoveObject(bucket, name)
+}
@@ -0,0 +1,150 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package chunker
+
+import (
+	"io"
+)
+
+const (
+	// MinSize is the smallest chunk that will be cut, except for the last
+	// chunk of a stream.
+	MinSize = 256 * 1024
+	// AvgSize is the chunk size the cut points are normalised around.
+	AvgSize = 1024 * 1024
+	// MaxSize is the largest chunk that will be cut.
+	MaxSize = 4 * 1024 * 1024
+
+	// maskS is used below AvgSize and has more bits set than a mask for
+	// AvgSize would, making a cut less likely. maskL is used above AvgSize
+	// and has fewer. This is FastCDC's "normalized chunking" and keeps the
+	// chunk sizes close to AvgSize.
+	maskS = uint64((1<<22)-1) << (64 - 22)
+	maskL = uint64((1<<18)-1) << (64 - 18)
+)
+
+// gear maps each byte value to a random 64 bit number for the rolling hash.
+// The table is generated from a fixed seed and must never change, otherwise
+// chunk boundaries, and with them deduplication, change between versions.
+var gear [256]uint64
+
+func init() {
+	// splitmix64
+	seed := uint64(0x68617368_74726565) // "hashtree"
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Chunk is a piece of the stream. Data is only valid until the next call to
+// Next.
+type Chunk struct {
+	Offset int64
+	Length int
+	Data   []byte
+}
+
+// Chunker splits a stream into content defined chunks using the FastCDC
+// algorithm. Inserting or removing bytes only changes the chunks around the
+// edit, the rest of the stream is cut at the same places as before.
+type Chunker struct {
+	r      io.Reader
+	buf    []byte
+	start  int
+	end    int
+	offset int64
+	eof    bool
+}
+
+// New returns a Chunker reading from r.
+func New(r io.Reader) *Chunker {
+	return &Chunker{r: r, buf: make([]byte, MaxSize)}
+}
+
+// Next returns the next chunk of the stream, or io.EOF once all of it has
+// been returned.
+func (c *Chunker) Next() (Chunk, error) {
+	if err := c.fill(); err != nil {
+		return Chunk{}, err
+	}
+	if c.start == c.end {
+		return Chunk{}, io.EOF
+	}
+	n := cut(c.buf[c.start:c.end])
+	chunk := Chunk{Offset: c.offset, Length: n, Data: c.buf[c.start : c.start+n]}
+	c.start += n
+	c.offset += int64(n)
+	return chunk, nil
+}
+
+// fill tops the buffer up to MaxSize bytes unless the stream has ended.
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= MaxSize {
+		return nil
+	}
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the first chunk in data.
+func cut(data []byte) int {
+	n := len(data)
+	if n <= MinSize {
+		return n
+	}
+	if n > MaxSize {
+		n = MaxSize
+	}
+	normal := AvgSize
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,122 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+// testdata returns n random bytes that are the same on every run.
+func testdata(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+// chunks returns the chunks r is cut into.
+func chunks(t *testing.T, r io.Reader) []Chunk {
+	var list []Chunk
+	c := New(r)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return list
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk.Data = append([]byte(nil), chunk.Data...)
+		list = append(list, chunk)
+	}
+}
+
+// ends returns the offsets the chunks end at.
+func ends(list []Chunk) []int64 {
+	var offsets []int64
+	for _, chunk := range list {
+		offsets = append(offsets, chunk.Offset+int64(chunk.Length))
+	}
+	return offsets
+}
+
+// TestGolden pins the cut points of a fixed stream. If it fails the gear
+// table or the cutting changed, and with them the chunks of every file
+// already backed up.
+func TestGolden(t *testing.T) {
+	want := []int64{1485449, 2807299, 3862797, 4937686, 6796604, 8137452, 9561351, 10611458, 11676880, 12213999, 13314874, 14586161, 15807535, 16777216}
+	data := testdata(16 << 20)
+	if got := ends(chunks(t, bytes.NewReader(data))); !reflect.DeepEqual(got, want) {
+		t.Errorf("cut at %v, want %v", got, want)
+	}
+	// short reads don't move the cut points
+	if got := ends(chunks(t, iotest.HalfReader(bytes.NewReader(data)))); !reflect.DeepEqual(got, want) {
+		t.Errorf("cut at %v with short reads, want %v", got, want)
+	}
+	if gear[0] != 0x74dd6399f4151dae || gear[255] != 0xb3e88a47f3efb44f {
+		t.Errorf("gear table changed")
+	}
+}
+
+func TestSizes(t *testing.T) {
+	list := chunks(t, bytes.NewReader(testdata(32<<20)))
+	for i, chunk := range list {
+		if chunk.Length > MaxSize || chunk.Length < MinSize && i < len(list)-1 {
+			t.Errorf("chunk %d is %d bytes", i, chunk.Length)
+		}
+	}
+	if list := chunks(t, bytes.NewReader(nil)); len(list) != 0 {
+		t.Errorf("empty stream gave %d chunks", len(list))
+	}
+	if list := chunks(t, bytes.NewReader(testdata(100))); len(list) != 1 || list[0].Length != 100 {
+		t.Errorf("short stream gave %v", ends(list))
+	}
+}
+
+// TestInsert checks that inserting a byte only changes the chunks around
+// it.
+func TestInsert(t *testing.T) {
+	data := testdata(16 << 20)
+	at := 7 << 20
+	edited := append(append(append([]byte(nil), data[:at]...), 'x'), data[at:]...)
+	before := make(map[[sha256.Size]byte]bool)
+	for _, chunk := range chunks(t, bytes.NewReader(data)) {
+		before[sha256.Sum256(chunk.Data)] = true
+	}
+	var changed []Chunk
+	for _, chunk := range chunks(t, bytes.NewReader(edited)) {
+		if !before[sha256.Sum256(chunk.Data)] {
+			changed = append(changed, chunk)
+		}
+	}
+	if len(changed) == 0 || len(changed) > 2 {
+		t.Fatalf("%d chunks changed, want 1 or 2", len(changed))
+	}
+	for _, chunk := range changed {
+		if chunk.Offset > int64(at) || chunk.Offset+int64(chunk.Length)+MaxSize < int64(at) {
+			t.Errorf("chunk at %d changed, far from the insertion at %d", chunk.Offset, at)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+
+// Command hashtree works on hashtree-mobile repositories from a shell, for
+// the upkeep that doesn't need the app:
+//
+//	hashtree -server URL -bucket NAME forget -keep last=3,daily=7 [-dry-run]
+//	hashtree -server URL -bucket NAME gc [-dry-run]
+//	hashtree -server URL -bucket NAME diff [-json] SNAPSHOT SNAPSHOT|DIR
+//	hashtree -server URL -bucket NAME ls [-json] SNAPSHOT [PREFIX]
+//	hashtree -server URL -bucket NAME restore [-nuke] SNAPSHOT DIR [PATH...]
+//
+// The access and secret keys are read from -access and -secret or from
+// HASHTREE_ACCESS_KEY and HASHTREE_SECRET_KEY, the encryption key from
+// HASHTREE_KEY so it doesn't end up in the shell history.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hashtree-mobile/android/hashfunc"
+	"os"
+	"strings"
+)
+
+// printer sends the progress messages of hashfunc to stderr.
+type printer struct{}
+
+func (printer) SendString(s string) {
+	fmt.Fprintln(os.Stderr, strings.TrimRight(s, "\n"))
+}
+
+// repo is the repository commands work on.
+type repo struct {
+	server, accesskey, secretkey, enckey, bucket string
+	secure                                       bool
+}
+
+// commands maps the name of each command to the function running it with
+// the arguments that follow the name. They return the exit status.
+var commands = map[string]func(r *repo, args []string) int{
+	"forget":  forget,
+	"gc":      gc,
+	"diff":    diff,
+	"ls":      ls,
+	"restore": restore,
+}
+
+func main() {
+	r := &repo{}
+	flag.StringVar(&r.server, "server", os.Getenv("HASHTREE_SERVER"), "S3 endpoint or file:///path of the repository")
+	flag.StringVar(&r.accesskey, "access", os.Getenv("HASHTREE_ACCESS_KEY"), "access key")
+	flag.StringVar(&r.secretkey, "secret", os.Getenv("HASHTREE_SECRET_KEY"), "secret key")
+	flag.StringVar(&r.bucket, "bucket", os.Getenv("HASHTREE_BUCKET"), "bucket of the repository")
+	flag.BoolVar(&r.secure, "secure", false, "use https")
+	flag.Usage = usage
+	flag.Parse()
+	r.enckey = os.Getenv("HASHTREE_KEY")
+	command, ok := commands[flag.Arg(0)]
+	if !ok || r.server == "" || r.bucket == "" {
+		usage()
+		os.Exit(2)
+	}
+	hashfunc.RegisterJavaCallback(printer{})
+	os.Exit(command(r, flag.Args()[1:]))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: hashtree [flags] command [command flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	fmt.Fprintln(os.Stderr, "  forget\tforget snapshots a retention policy doesn't keep")
+	fmt.Fprintln(os.Stderr, "  gc\tremove content no snapshot refers to")
+	fmt.Fprintln(os.Stderr, "  diff\tshow the changes between two snapshots or a snapshot and a directory")
+	fmt.Fprintln(os.Stderr, "  ls\tlist the files of a snapshot without restoring them")
+	fmt.Fprintln(os.Stderr, "  restore\trestore a snapshot, or only the paths or globs given, to a directory")
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	flag.PrintDefaults()
+}
+
+// result prints the result of a hashfunc call and returns the exit status.
+func result(out string) int {
+	if out == "ERROR" {
+		return 1
+	}
+	if out != "" {
+		fmt.Println(out)
+	}
+	return 0
+}
+
+func forget(r *repo, args []string) int {
+	fs := flag.NewFlagSet("forget", flag.ExitOnError)
+	keep := fs.String("keep", "", "retention policy, comma separated rules of last=n, hourly=n, daily=n, weekly=n, monthly=n, yearly=n and tag=name")
+	dryrun := fs.Bool("dry-run", false, "only show what would be forgotten")
+	fs.Parse(args)
+	return result(hashfunc.Hashforget(r.server, r.accesskey, r.secretkey, r.enckey, r.bucket, r.secure, *keep, *dryrun))
+}
+
+func gc(r *repo, args []string) int {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryrun := fs.Bool("dry-run", false, "only show what would be removed")
+	fs.Parse(args)
+	return result(hashfunc.Hashgc(r.server, r.accesskey, r.secretkey, r.enckey, r.bucket, r.secure, *dryrun))
+}
+
+func diff(r *repo, args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asjson := fs.Bool("json", false, "print the changes as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: hashtree diff [-json] SNAPSHOT SNAPSHOT|DIR")
+		return 2
+	}
+	from, to := fs.Arg(0), fs.Arg(1)
+	// a directory on this machine is compared as it is now
+	if info, err := os.Stat(to); err == nil && info.IsDir() {
+		return result(hashfunc.Hashdifflocal(r.server, r.accesskey, r.secretkey, r.enckey, r.bucket, r.secure, from, to, *asjson))
+	}
+	return result(hashfunc.Hashdiff(r.server, r.accesskey, r.secretkey, r.enckey, r.bucket, r.secure, from, to, *asjson))
+}
+
+func ls(r *repo, args []string) int {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	asjson := fs.Bool("json", false, "print the files as JSON")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintln(os.Stderr, "usage: hashtree ls [-json] SNAPSHOT [PREFIX]")
+		return 2
+	}
+	return result(hashfunc.Hashls(r.server, r.accesskey, r.secretkey, r.enckey, r.bucket, r.secure, fs.Arg(0), fs.Arg(1), *asjson))
+}
+
+func restore(r *repo, args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	nuke := fs.Bool("nuke", false, "replace files that are in the way")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "usage: hashtree restore [-nuke] SNAPSHOT DIR [PATH...]")
+		return 2
+	}
+	var ok bool
+	if fs.NArg() == 2 {
+		ok = hashfunc.Hashseed(r.server, r.accesskey, r.secretkey, r.enckey, fs.Arg(0), r.bucket, r.secure, fs.Arg(1), *nuke)
+	} else {
+		paths := strings.Join(fs.Args()[2:], "\n")
+		ok = hashfunc.Hashseedpaths(r.server, r.accesskey, r.secretkey, r.enckey, fs.Arg(0), r.bucket, r.secure, fs.Arg(1), *nuke, paths)
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
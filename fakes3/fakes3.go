@@ -0,0 +1,523 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package fakes3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory stand-in for an S3 endpoint. It implements the
+// part of the S3 API that minio-go uses in this project: bucket exists,
+// make bucket, bucket location, put (single and multipart), get (including
+// range requests), stat, list (v1 and v2) and delete object. Requests are not
+// authenticated, any access and secret key is accepted.
+type Server struct {
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]map[string]*object
+	uploads map[string]*upload
+	nextID  int
+}
+
+type object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+type upload struct {
+	bucket string
+	key    string
+	parts  map[int][]byte
+}
+
+// NewServer starts a Server listening on a local port. The caller must call
+// Close when done.
+func NewServer() *Server {
+	s := &Server{
+		buckets: make(map[string]map[string]*object),
+		uploads: make(map[string]*upload),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Endpoint returns the host:port of the server, suitable as the server
+// argument of minio.New or the hashfunc API with secure set to false.
+func (s *Server) Endpoint() string {
+	return strings.TrimPrefix(s.srv.URL, "http://")
+}
+
+// URL returns the base URL of the server.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Keys returns the sorted names of the objects stored in bucket.
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Object returns a copy of the contents of an object and whether it exists.
+func (s *Server) Object(bucket string, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+// errorResponse is the body S3 sends along with a failed request.
+type errorResponse struct {
+	XMLName    xml.Name `xml:"Error"`
+	Code       string
+	Message    string
+	BucketName string
+	Key        string
+	RequestID  string `xml:"RequestId"`
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, bucket string, key string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, errorResponse{Code: code, Message: code, BucketName: bucket, Key: key, RequestID: "fakes3"})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// Only path style requests are supported, which is what minio-go uses
+	// for endpoints that are not Amazon S3.
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := p, ""
+	if i := strings.Index(p, "/"); i >= 0 {
+		bucket, key = p[:i], p[i+1:]
+	}
+	if bucket == "" {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "", "")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key == "" {
+		s.serveBucket(w, r, bucket)
+		return
+	}
+	s.serveObject(w, r, bucket, key)
+}
+
+func (s *Server) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	objects, exists := s.buckets[bucket]
+	switch r.Method {
+	case http.MethodPut:
+		if exists {
+			writeError(w, r, http.StatusConflict, "BucketAlreadyOwnedByYou", bucket, "")
+			return
+		}
+		s.buckets[bucket] = make(map[string]*object)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", bucket, "")
+		return
+	}
+	switch r.Method {
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		if _, ok := query["location"]; ok {
+			writeXML(w, http.StatusOK, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+			}{})
+			return
+		}
+		s.list(w, query, bucket, objects)
+	case http.MethodDelete:
+		if len(objects) > 0 {
+			writeError(w, r, http.StatusConflict, "BucketNotEmpty", bucket, "")
+			return
+		}
+		delete(s.buckets, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", bucket, "")
+	}
+}
+
+type listEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+type listBucketResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string
+	Prefix         string
+	Marker         string `xml:",omitempty"`
+	NextMarker     string `xml:",omitempty"`
+	StartAfter     string `xml:",omitempty"`
+	KeyCount       int    `xml:",omitempty"`
+	MaxKeys        int
+	Delimiter      string
+	IsTruncated    bool
+	Contents       []listEntry
+	CommonPrefixes []commonPrefix
+
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+}
+
+// list answers both ListObjects (v1) and ListObjectsV2 requests. Keys are
+// returned in lexical order and grouped by delimiter when one is given.
+func (s *Server) list(w http.ResponseWriter, query map[string][]string, bucket string, objects map[string]*object) {
+	get := func(name string) string {
+		if v := query[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	prefix, delimiter := get("prefix"), get("delimiter")
+	v2 := get("list-type") == "2"
+	marker := get("marker")
+	if v2 {
+		marker = get("start-after")
+		if token := get("continuation-token"); token != "" {
+			marker = token
+		}
+	}
+	maxKeys := 1000
+	if n, err := strconv.Atoi(get("max-keys")); err == nil && n > 0 && n < maxKeys {
+		maxKeys = n
+	}
+
+	var keys []string
+	for key := range objects {
+		if !strings.HasPrefix(key, prefix) || key <= marker {
+			continue
+		}
+		// a page that ended with a common prefix continues after all of it
+		if delimiter != "" && strings.HasSuffix(marker, delimiter) && strings.HasPrefix(key, marker) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{Name: bucket, Prefix: prefix, MaxKeys: maxKeys, Delimiter: delimiter}
+	seen := make(map[string]bool)
+	var last string
+	for _, key := range keys {
+		cp := ""
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				cp = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if seen[cp] {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if cp != "" {
+			seen[cp] = true
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: cp})
+			last = cp
+			continue
+		}
+		obj := objects[key]
+		result.Contents = append(result.Contents, listEntry{
+			Key:          key,
+			LastModified: obj.modTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         `"` + obj.etag + `"`,
+			Size:         int64(len(obj.data)),
+			StorageClass: "STANDARD",
+		})
+		last = key
+	}
+	if v2 {
+		result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+		result.StartAfter = get("start-after")
+		result.ContinuationToken = get("continuation-token")
+		if result.IsTruncated {
+			result.NextContinuationToken = last
+		}
+	} else {
+		result.Marker = get("marker")
+		if result.IsTruncated {
+			result.NextMarker = last
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) serveObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	query := r.URL.Query()
+	objects, exists := s.buckets[bucket]
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", bucket, key)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		if _, ok := query["uploads"]; ok {
+			s.nextID++
+			id := strconv.Itoa(s.nextID)
+			s.uploads[id] = &upload{bucket: bucket, key: key, parts: make(map[int][]byte)}
+			writeXML(w, http.StatusOK, struct {
+				XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+				Bucket   string
+				Key      string
+				UploadID string `xml:"UploadId"`
+			}{Bucket: bucket, Key: key, UploadID: id})
+			return
+		}
+		if id := query.Get("uploadId"); id != "" {
+			s.completeUpload(w, r, id, bucket, key, objects)
+			return
+		}
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", bucket, key)
+	case http.MethodPut:
+		data, err := readBody(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "IncompleteBody", bucket, key)
+			return
+		}
+		if id := query.Get("uploadId"); id != "" {
+			up, ok := s.uploads[id]
+			n, err := strconv.Atoi(query.Get("partNumber"))
+			if !ok || err != nil {
+				writeError(w, r, http.StatusNotFound, "NoSuchUpload", bucket, key)
+				return
+			}
+			up.parts[n] = data
+			w.Header().Set("ETag", `"`+etag(data)+`"`)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		obj := &object{data: data, etag: etag(data), modTime: time.Now()}
+		objects[key] = obj
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		obj, ok := objects[key]
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey", bucket, key)
+			return
+		}
+		serveContent(w, r, obj)
+	case http.MethodDelete:
+		if id := query.Get("uploadId"); id != "" {
+			delete(s.uploads, id)
+		} else {
+			delete(objects, key)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", bucket, key)
+	}
+}
+
+// readBody returns the payload of a PUT request. Over plain http minio-go
+// signs uploads with the streaming signature scheme, which wraps the payload
+// in "aws-chunked" framing:
+//
+//	<hex size>;chunk-signature=<signature>\r\n<data>\r\n ... 0;chunk-signature=<signature>\r\n\r\n
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		return body, nil
+	}
+	var data []byte
+	for {
+		i := bytes.Index(body, []byte("\r\n"))
+		if i < 0 {
+			return nil, fmt.Errorf("fakes3: malformed chunk header")
+		}
+		header := string(body[:i])
+		if j := strings.Index(header, ";"); j >= 0 {
+			header = header[:j]
+		}
+		size, err := strconv.ParseInt(header, 16, 64)
+		if err != nil || int64(len(body)) < int64(i)+2+size {
+			return nil, fmt.Errorf("fakes3: malformed chunk header")
+		}
+		body = body[i+2:]
+		if size == 0 {
+			return data, nil
+		}
+		data = append(data, body[:size]...)
+		body = bytes.TrimPrefix(body[size:], []byte("\r\n"))
+	}
+}
+
+func (s *Server) completeUpload(w http.ResponseWriter, r *http.Request, id string, bucket string, key string, objects map[string]*object) {
+	up, ok := s.uploads[id]
+	if !ok || up.bucket != bucket || up.key != key {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", bucket, key)
+		return
+	}
+	var complete struct {
+		Parts []struct {
+			PartNumber int
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", bucket, key)
+		return
+	}
+	var buf bytes.Buffer
+	for _, part := range complete.Parts {
+		data, ok := up.parts[part.PartNumber]
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart", bucket, key)
+			return
+		}
+		buf.Write(data)
+	}
+	delete(s.uploads, id)
+	obj := &object{data: buf.Bytes(), etag: fmt.Sprintf("%s-%d", etag(buf.Bytes()), len(complete.Parts)), modTime: time.Now()}
+	objects[key] = obj
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: bucket, Key: key, ETag: `"` + obj.etag + `"`})
+}
+
+// serveContent writes obj honouring a single "bytes=" range if one was
+// requested.
+func serveContent(w http.ResponseWriter, r *http.Request, obj *object) {
+	h := w.Header()
+	h.Set("ETag", `"`+obj.etag+`"`)
+	h.Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Accept-Ranges", "bytes")
+
+	data := obj.data
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, end, ok := parseRange(rng, int64(len(data)))
+		if !ok {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+			writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "", "")
+			return
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+// parseRange parses "bytes=a-b", "bytes=a-" and "bytes=-n" and returns the
+// inclusive byte range it selects.
+func parseRange(rng string, size int64) (int64, int64, bool) {
+	spec := strings.TrimPrefix(rng, "bytes=")
+	i := strings.Index(spec, "-")
+	if spec == rng || i < 0 || size == 0 {
+		return 0, 0, false
+	}
+	first, last := spec[:i], spec[i+1:]
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
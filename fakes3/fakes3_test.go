@@ -0,0 +1,274 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package fakes3
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	minio "github.com/minio/minio-go"
+)
+
+// client returns a minio client of s with a bucket named test.
+func client(t *testing.T, s *Server) *minio.Client {
+	t.Helper()
+	c, err := minio.New(s.Endpoint(), "access", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MakeBucket("test", ""); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// testdata returns n bytes that are the same on every run.
+func testdata(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(b)
+	return b
+}
+
+func TestBuckets(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(t, s)
+	if ok, err := c.BucketExists("test"); !ok || err != nil {
+		t.Errorf("test exists %v, %v", ok, err)
+	}
+	if ok, err := c.BucketExists("other"); ok || err != nil {
+		t.Errorf("other exists %v, %v", ok, err)
+	}
+	if err := c.MakeBucket("test", ""); err == nil {
+		t.Error("made test twice")
+	}
+	if _, err := c.PutObject("other", "a", strings.NewReader("a"), 1, minio.PutObjectOptions{}); err == nil {
+		t.Error("put into a bucket that doesn't exist")
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(t, s)
+	data := testdata(100 * 1024)
+	// over http minio-go sends the payload in aws-chunked framing
+	if _, err := c.PutObject("test", "dir/a", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.Object("test", "dir/a"); !ok || !bytes.Equal(got, data) {
+		t.Fatalf("stored %d bytes, want %d", len(got), len(data))
+	}
+	if _, err := c.PutObject("test", "empty", bytes.NewReader(nil), 0, minio.PutObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := c.StatObject("test", "dir/a", minio.StatObjectOptions{})
+	if err != nil || info.Size != int64(len(data)) {
+		t.Errorf("stat gives %d bytes, %v", info.Size, err)
+	}
+	if _, err := c.StatObject("test", "missing", minio.StatObjectOptions{}); minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		t.Errorf("stat of a missing object gives %v", err)
+	}
+
+	tests := []struct {
+		start, end int64
+		want       []byte
+	}{
+		{0, 0, data},
+		{0, 9, data[:10]},
+		{10, 19, data[10:20]},
+		{1000, 0, data[1000:]},
+		{0, -10, data[len(data)-10:]},
+		{int64(len(data)) - 5, int64(len(data)) + 100, data[len(data)-5:]},
+	}
+	for _, test := range tests {
+		opts := minio.GetObjectOptions{}
+		if test.start != 0 || test.end != 0 {
+			opts.SetRange(test.start, test.end)
+		}
+		obj, _, err := minio.Core{Client: c}.GetObject("test", "dir/a", opts)
+		if err != nil {
+			t.Fatalf("range %d-%d: %v", test.start, test.end, err)
+		}
+		got, err := ioutil.ReadAll(obj)
+		obj.Close()
+		if err != nil || !bytes.Equal(got, test.want) {
+			t.Errorf("range %d-%d gives %d bytes, want %d, %v", test.start, test.end, len(got), len(test.want), err)
+		}
+	}
+	opts := minio.GetObjectOptions{}
+	opts.SetRange(int64(len(data)), 0)
+	if _, _, err := (minio.Core{Client: c}).GetObject("test", "dir/a", opts); minio.ToErrorResponse(err).Code != "InvalidRange" {
+		t.Errorf("range past the end gives %v", err)
+	}
+
+	if err := c.RemoveObject("test", "dir/a"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.Keys("test"), []string{"empty"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys %v after remove, want %v", got, want)
+	}
+}
+
+// TestChunked sends an aws-chunked body by hand, as minio-go does over
+// http.
+func TestChunked(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client(t, s)
+	var body bytes.Buffer
+	for _, chunk := range []string{"hello, ", "world", ""} {
+		fmt.Fprintf(&body, "%x;chunk-signature=0000\r\n%s\r\n", len(chunk), chunk)
+	}
+	put := func(body string) int {
+		req, err := http.NewRequest(http.MethodPut, s.URL()+"/test/a", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Amz-Content-Sha256", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := put(body.String()); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if got, _ := s.Object("test", "a"); string(got) != "hello, world" {
+		t.Errorf("stored %q", got)
+	}
+	for _, bad := range []string{"hello", "x;chunk-signature=0\r\n", "ff;chunk-signature=0\r\nshort\r\n"} {
+		if status := put(bad); status != http.StatusBadRequest {
+			t.Errorf("%q gives status %d", bad, status)
+		}
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := minio.Core{Client: client(t, s)}
+	data := testdata(3 * 1024)
+	id, err := c.NewMultipartUpload("test", "big", minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// parts may be sent in any order, the list completing the upload
+	// decides
+	var parts []minio.CompletePart
+	for _, n := range []int{3, 1, 2} {
+		chunk := data[(n-1)*1024 : n*1024]
+		part, err := c.PutObjectPart("test", "big", id, n, bytes.NewReader(chunk), int64(len(chunk)), "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: n, ETag: part.ETag})
+	}
+	if _, ok := s.Object("test", "big"); ok {
+		t.Fatal("object exists before the upload is complete")
+	}
+	parts[0], parts[1], parts[2] = parts[1], parts[2], parts[0]
+	if _, err := c.CompleteMultipartUpload("test", "big", id, parts); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Object("test", "big"); !bytes.Equal(got, data) {
+		t.Errorf("stored %d bytes, want %d", len(got), len(data))
+	}
+	if _, err := c.CompleteMultipartUpload("test", "big", id, parts); err == nil {
+		t.Error("completed an upload twice")
+	}
+}
+
+func TestList(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(t, s)
+	var want []string
+	for i := 0; i < 1100; i++ {
+		key := fmt.Sprintf("a/%04d", i)
+		want = append(want, key)
+		if _, err := c.PutObject("test", key, strings.NewReader("x"), 1, minio.PutObjectOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"b/1", "b/c/2", "c"} {
+		want = append(want, key)
+		if _, err := c.PutObject("test", key, strings.NewReader("x"), 1, minio.PutObjectOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the clients page through more keys than fit in one answer
+	for _, v2 := range []bool{false, true} {
+		list := c.ListObjects
+		if v2 {
+			list = c.ListObjectsV2
+		}
+		var got []string
+		for obj := range list("test", "", true, nil) {
+			if obj.Err != nil {
+				t.Fatal(obj.Err)
+			}
+			got = append(got, obj.Key)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("v2 %v lists %d keys, want %d", v2, len(got), len(want))
+		}
+		got = nil
+		for obj := range list("test", "b/", false, nil) {
+			got = append(got, obj.Key)
+		}
+		if w := []string{"b/1", "b/c/"}; !reflect.DeepEqual(got, w) {
+			t.Errorf("v2 %v lists %v in b/, want %v", v2, got, w)
+		}
+	}
+
+	// one page at a time
+	core := minio.Core{Client: c}
+	page, err := core.ListObjects("test", "a/", "", "", 500)
+	if err != nil || len(page.Contents) != 500 || !page.IsTruncated || page.NextMarker != "a/0499" {
+		t.Errorf("v1 page of %d keys, truncated %v, next %q, %v", len(page.Contents), page.IsTruncated, page.NextMarker, err)
+	}
+	page, err = core.ListObjects("test", "a/", "a/1049", "", 500)
+	if err != nil || len(page.Contents) != 50 || page.IsTruncated || page.Contents[0].Key != "a/1050" {
+		t.Errorf("v1 last page of %d keys, truncated %v, %v", len(page.Contents), page.IsTruncated, err)
+	}
+	page2, err := core.ListObjectsV2("test", "", "", false, "/", 2, "")
+	if err != nil || len(page2.Contents) != 0 || !page2.IsTruncated || len(page2.CommonPrefixes) != 2 {
+		t.Fatalf("v2 page %+v, %v", page2, err)
+	}
+	page2, err = core.ListObjectsV2("test", "", page2.NextContinuationToken, false, "/", 2, "")
+	if err != nil || page2.IsTruncated || len(page2.CommonPrefixes) != 0 || len(page2.Contents) != 1 || page2.Contents[0].Key != "c" {
+		t.Errorf("v2 last page %+v, %v", page2, err)
+	}
+	page2, err = core.ListObjectsV2("test", "", "", false, "", 1, "b/1")
+	if err != nil || len(page2.Contents) != 1 || page2.Contents[0].Key != "b/c/2" {
+		t.Errorf("v2 page after b/1 %+v, %v", page2, err)
+	}
+}
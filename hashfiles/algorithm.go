@@ -0,0 +1,95 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package hashfiles
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/zeebo/blake3"
+)
+
+// Algorithm names the hash used to identify file contents.
+type Algorithm string
+
+const (
+	// SHA256 is the hash of repositories made before the hash could be
+	// chosen, and the default.
+	SHA256 Algorithm = "sha256"
+	// BLAKE3 is much faster than SHA256 on most devices.
+	BLAKE3 Algorithm = "blake3"
+	// SHA512_256 is faster than SHA256 on 64 bit devices.
+	SHA512_256 Algorithm = "sha512_256"
+)
+
+// ParseAlgorithm returns the Algorithm called name.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch a := Algorithm(name); a {
+	case SHA256, BLAKE3, SHA512_256:
+		return a, nil
+	case "":
+		return SHA256, nil
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q", name)
+}
+
+// New returns a new hash.Hash computing a.
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case BLAKE3:
+		return blake3.New()
+	case SHA512_256:
+		return sha512.New512_256()
+	}
+	return sha256.New()
+}
+
+// Key returns the key a file with the given digest is stored under in the
+// index and the bucket. SHA256 keys are the bare hex digest, as they have
+// always been, other keys are tagged with the name of their algorithm.
+func (a Algorithm) Key(digest []byte) string {
+	if a == SHA256 || a == "" {
+		return hex.EncodeToString(digest)
+	}
+	return string(a) + "-" + hex.EncodeToString(digest)
+}
+
+// ParseKey returns the algorithm and digest of a key made by Key. ok is false
+// if key isn't a content hash, for example the name of a database.
+func ParseKey(key string) (a Algorithm, digest []byte, ok bool) {
+	a = SHA256
+	if i := strings.LastIndex(key, "-"); i >= 0 {
+		var err error
+		a, err = ParseAlgorithm(key[:i])
+		if err != nil || a == SHA256 {
+			return "", nil, false
+		}
+		key = key[i+1:]
+	}
+	digest, err := hex.DecodeString(key)
+	if err != nil || len(digest) != a.New().Size() {
+		return "", nil, false
+	}
+	return a, digest, true
+}
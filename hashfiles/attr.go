@@ -0,0 +1,316 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package hashfiles
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of the entries of a tree that aren't regular files.
+const (
+	TypeDir     = "dir"
+	TypeSymlink = "symlink"
+	TypeFifo    = "fifo"
+)
+
+// Attr is the metadata of a file that is kept in a snapshot next to its
+// contents. An Attr with a zero ModTime carries only the type of the entry
+// and, for links, the target.
+type Attr struct {
+	// Type is the type of the entry, "" for regular files.
+	Type string
+	// Target is the target of a symbolic link.
+	Target string
+	// Link is the path, relative to the root of the tree, of the file a
+	// regular file is a hard link to.
+	Link string
+	// Mode holds the permission bits along with setuid, setgid and sticky.
+	Mode os.FileMode
+	// ModTime is the modification time of the file.
+	ModTime time.Time
+	// Size is the size of a regular file, -1 if unknown. It is only
+	// recorded, restoring a file gives it the size of its contents.
+	Size int64
+	// Uid and Gid are the owner of the file, -1 if unknown.
+	Uid, Gid int
+	// Xattrs holds the extended attributes of the file by name.
+	Xattrs map[string][]byte
+}
+
+// modeBits are the bits of an os.FileMode kept in an Attr.
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// ReadAttr returns the metadata of the file at path, described by info. If
+// the extended attributes can't be read the rest of the metadata is returned
+// along with the error.
+func ReadAttr(path string, info os.FileInfo) (*Attr, error) {
+	a := &Attr{Type: TypeOf(info), Mode: info.Mode() & modeBits, ModTime: info.ModTime(), Size: -1}
+	if a.Type == "" {
+		a.Size = info.Size()
+	}
+	a.Uid, a.Gid = owner(info)
+	if a.Type == TypeSymlink {
+		// the permissions and times of links can't be set and reading
+		// extended attributes would follow the link
+		target, err := os.Readlink(path)
+		return &Attr{Type: TypeSymlink, Target: target, Size: -1, Uid: a.Uid, Gid: a.Gid}, err
+	}
+	xattrs, err := readXattrs(path)
+	a.Xattrs = xattrs
+	return a, err
+}
+
+// TypeOf returns the type of the entry described by info, "" for a regular
+// file or anything else that isn't a directory, link or named pipe.
+func TypeOf(info os.FileInfo) string {
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		return TypeDir
+	case mode&os.ModeSymlink != 0:
+		return TypeSymlink
+	case mode&os.ModeNamedPipe != 0:
+		return TypeFifo
+	}
+	return ""
+}
+
+// Fields returns the metadata as a list of key=value fields, the way it is
+// stored in a snapshot.
+func (a *Attr) Fields() []string {
+	var fields []string
+	if a.Type != "" {
+		fields = append(fields, "type="+a.Type)
+	}
+	if a.Type == TypeSymlink {
+		fields = append(fields, "target="+a.Target)
+	}
+	if a.Link != "" {
+		fields = append(fields, "link="+a.Link)
+	}
+	if !a.ModTime.IsZero() {
+		fields = append(fields,
+			"mode="+strconv.FormatUint(uint64(unixMode(a.Mode)), 8),
+			"mtime="+strconv.FormatInt(a.ModTime.UnixNano(), 10),
+		)
+		if a.Type == "" && a.Size >= 0 {
+			fields = append(fields, "size="+strconv.FormatInt(a.Size, 10))
+		}
+	}
+	if a.Uid >= 0 && a.Gid >= 0 {
+		fields = append(fields, "uid="+strconv.Itoa(a.Uid), "gid="+strconv.Itoa(a.Gid))
+	}
+	var names []string
+	for name := range a.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, "xattr="+name+"="+base64.RawStdEncoding.EncodeToString(a.Xattrs[name]))
+	}
+	return fields
+}
+
+// ParseAttr parses metadata stored by Fields. Fields it doesn't know are
+// skipped.
+func ParseAttr(fields []string) (*Attr, error) {
+	a := &Attr{Mode: 0644, Size: -1, Uid: -1, Gid: -1}
+	for _, field := range fields {
+		i := strings.Index(field, "=")
+		if i < 0 {
+			return nil, errors.New("invalid attribute " + field)
+		}
+		key, value := field[:i], field[i+1:]
+		var err error
+		switch key {
+		case "type":
+			a.Type = value
+		case "target":
+			a.Target = value
+		case "link":
+			a.Link = value
+		case "mode":
+			var m uint64
+			m, err = strconv.ParseUint(value, 8, 32)
+			a.Mode = fileMode(uint32(m))
+		case "mtime":
+			var ns int64
+			ns, err = strconv.ParseInt(value, 10, 64)
+			a.ModTime = time.Unix(0, ns)
+		case "size":
+			a.Size, err = strconv.ParseInt(value, 10, 64)
+		case "uid":
+			a.Uid, err = strconv.Atoi(value)
+		case "gid":
+			a.Gid, err = strconv.Atoi(value)
+		case "xattr":
+			j := strings.LastIndex(value, "=")
+			if j < 0 {
+				return nil, errors.New("invalid attribute " + field)
+			}
+			var data []byte
+			data, err = base64.RawStdEncoding.DecodeString(value[j+1:])
+			if a.Xattrs == nil {
+				a.Xattrs = make(map[string][]byte)
+			}
+			a.Xattrs[value[:j]] = data
+		}
+		if err != nil {
+			return nil, errors.New("invalid attribute " + field)
+		}
+	}
+	return a, nil
+}
+
+// Create creates the entry at path if it isn't a regular file: a directory,
+// a symbolic link or a named pipe. An entry that is already there is
+// replaced if replace is true and it differs, otherwise an error is
+// returned.
+func (a *Attr) Create(path string, replace bool) error {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if a.same(path, info) {
+			return nil
+		}
+		if !replace {
+			return errors.New("a different file is in the way")
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	switch a.Type {
+	case TypeDir:
+		return os.Mkdir(path, os.ModePerm)
+	case TypeSymlink:
+		return os.Symlink(a.Target, path)
+	case TypeFifo:
+		return mkfifo(path, 0644)
+	}
+	return errors.New("unable to create a file of type " + a.Type)
+}
+
+// Link makes path a hard link to the file at target. A different file at
+// path is replaced if replace is true, otherwise an error is returned.
+func Link(target string, path string, replace bool) error {
+	info, err := os.Lstat(path)
+	if err == nil {
+		tinfo, err := os.Lstat(target)
+		if err == nil && os.SameFile(info, tinfo) {
+			return nil
+		}
+		if !replace {
+			return errors.New("a different file is in the way")
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Link(target, path)
+}
+
+// same reports whether the entry at path, described by info, already is what
+// a describes.
+func (a *Attr) same(path string, info os.FileInfo) bool {
+	if TypeOf(info) != a.Type {
+		return false
+	}
+	if a.Type == TypeSymlink {
+		target, err := os.Readlink(path)
+		return err == nil && target == a.Target
+	}
+	return true
+}
+
+// Apply sets the metadata of the file at path. The owner is only set if
+// owner is true, which usually takes root. All of the metadata is applied
+// even if part of it fails, the first error is returned. Only the owner of a
+// symbolic link is set, setting the rest would change its target.
+func (a *Attr) Apply(path string, owner bool) error {
+	var first error
+	keep := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+	for name, data := range a.Xattrs {
+		if err := writeXattr(path, name, data); err != nil {
+			keep(err)
+		}
+	}
+	// chown clears setuid and setgid, so it goes before chmod
+	if owner && a.Uid >= 0 && a.Gid >= 0 {
+		if err := os.Lchown(path, a.Uid, a.Gid); err != nil {
+			keep(err)
+		}
+	}
+	if a.Type == TypeSymlink || a.ModTime.IsZero() {
+		return first
+	}
+	if err := os.Chmod(path, a.Mode); err != nil {
+		keep(err)
+	}
+	if err := os.Chtimes(path, a.ModTime, a.ModTime); err != nil {
+		keep(err)
+	}
+	return first
+}
+
+// unixMode returns the unix permission bits of m.
+func unixMode(m os.FileMode) uint32 {
+	bits := uint32(m & os.ModePerm)
+	if m&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if m&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if m&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// fileMode is the reverse of unixMode.
+func fileMode(bits uint32) os.FileMode {
+	m := os.FileMode(bits) & os.ModePerm
+	if bits&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
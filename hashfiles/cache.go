@@ -0,0 +1,145 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package hashfiles
+
+import (
+	"hashtree-mobile/readdb"
+	"hashtree-mobile/writedb"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RacyWindow is how long after it was last modified a file is still
+// considered to be changing. A file hashed within RacyWindow of its
+// modification time could be rewritten without its modification time
+// changing, as FAT and exFAT only keep it to two seconds, so its cache entry
+// isn't trusted until it is hashed again later.
+var RacyWindow = 2 * time.Second
+
+// Cache remembers the hashes of files that were scanned before, keyed on
+// their path, size, modification time and inode. A file whose attributes
+// haven't changed since is not read again, unless it was modified too
+// shortly before it was hashed, see RacyWindow.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	used    map[string]bool
+}
+
+type cacheEntry struct {
+	size  int64
+	mtime int64
+	inode uint64
+	key   string
+	// stored is when the file was hashed
+	stored int64
+}
+
+// NewCache returns an empty Cache.
+func NewCache() *Cache {
+	return &Cache{entries: make(map[string]cacheEntry), used: make(map[string]bool)}
+}
+
+// LoadCache reads the cache stored at path. A missing cache file gives an
+// empty Cache, entries that can't be parsed are dropped.
+func LoadCache(path string) (*Cache, error) {
+	c := NewCache()
+	db, err := readdb.Load(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	// stored as path -> [ size, mtime, inode, key, stored ], caches written
+	// before stored was added leave it out and are verified once
+	for file, fields := range db {
+		if len(fields) != 4 && len(fields) != 5 {
+			continue
+		}
+		var e cacheEntry
+		var err1, err2, err3, err4 error
+		e.size, err1 = strconv.ParseInt(fields[0], 10, 64)
+		e.mtime, err2 = strconv.ParseInt(fields[1], 10, 64)
+		e.inode, err3 = strconv.ParseUint(fields[2], 10, 64)
+		if len(fields) == 5 {
+			e.stored, err4 = strconv.ParseInt(fields[4], 10, 64)
+		}
+		if _, _, ok := ParseKey(fields[3]); !ok || err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			continue
+		}
+		e.key = fields[3]
+		c.entries[file] = e
+	}
+	return c, nil
+}
+
+// Save writes the cache to path. Only the files looked up or added since
+// the cache was loaded are kept, so files that have gone away drop out.
+func (c *Cache) Save(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	db := make(map[string][]string)
+	for file := range c.used {
+		e, ok := c.entries[file]
+		if !ok {
+			continue
+		}
+		db[file] = []string{
+			strconv.FormatInt(e.size, 10),
+			strconv.FormatInt(e.mtime, 10),
+			strconv.FormatUint(e.inode, 10),
+			e.key,
+			strconv.FormatInt(e.stored, 10),
+		}
+	}
+	return writedb.Dump(path, db)
+}
+
+// Lookup returns the cached key of path if info still matches what was
+// cached, it was hashed with alg and it wasn't modified within RacyWindow
+// of being hashed.
+func (c *Cache) Lookup(path string, info os.FileInfo, alg Algorithm) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used[path] = true
+	e, ok := c.entries[path]
+	if !ok || e.size != info.Size() || e.mtime != info.ModTime().UnixNano() || e.inode != inode(info) {
+		return "", false
+	}
+	if a, _, _ := ParseKey(e.key); a != alg {
+		return "", false
+	}
+	// the file may have changed again since without its mtime changing
+	if e.mtime+int64(RacyWindow) >= e.stored {
+		return "", false
+	}
+	return e.key, true
+}
+
+// Store adds the key of path, hashed just now, to the cache.
+func (c *Cache) Store(path string, info os.FileInfo, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used[path] = true
+	c.entries[path] = cacheEntry{size: info.Size(), mtime: info.ModTime().UnixNano(), inode: inode(info), key: key, stored: time.Now().UnixNano()}
+}
@@ -0,0 +1,201 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package hashfiles
+
+import (
+	"hashtree-mobile/writedb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// cachedfile writes data to the file at p, last modified at mtime, and
+// returns its os.FileInfo.
+func cachedfile(t *testing.T, p string, data string, mtime time.Time) os.FileInfo {
+	t.Helper()
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// testkey returns the SHA256 key of s.
+func testkey(s string) string {
+	h := SHA256.New()
+	h.Write([]byte(s))
+	return SHA256.Key(h.Sum(nil))
+}
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "a")
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name   string
+		change func() os.FileInfo
+		alg    Algorithm
+		hit    bool
+	}{
+		{"unchanged", func() os.FileInfo {
+			info, _ := os.Stat(p)
+			return info
+		}, SHA256, true},
+		{"other algorithm", func() os.FileInfo {
+			info, _ := os.Stat(p)
+			return info
+		}, BLAKE3, false},
+		{"size", func() os.FileInfo {
+			return cachedfile(t, p, "hello, world", then)
+		}, SHA256, false},
+		{"mtime", func() os.FileInfo {
+			return cachedfile(t, p, "hello", then.Add(time.Second))
+		}, SHA256, false},
+		{"inode", func() os.FileInfo {
+			// replaced by another file with the same size and mtime
+			cachedfile(t, p+".new", "jello", then)
+			if err := os.Rename(p+".new", p); err != nil {
+				t.Fatal(err)
+			}
+			info, _ := os.Stat(p)
+			return info
+		}, SHA256, runtime.GOOS == "windows"},
+	}
+	for _, test := range tests {
+		c := NewCache()
+		info := cachedfile(t, p, "hello", then)
+		c.Store(p, info, testkey("digest"))
+		key, ok := c.Lookup(p, test.change(), test.alg)
+		if ok != test.hit {
+			t.Errorf("%s: hit %v, want %v", test.name, ok, test.hit)
+		}
+		if ok && key != testkey("digest") {
+			t.Errorf("%s: key %s", test.name, key)
+		}
+	}
+	if _, ok := NewCache().Lookup(p, cachedfile(t, p, "hello", then), SHA256); ok {
+		t.Error("hit in an empty cache")
+	}
+}
+
+// TestCacheRacy checks that a file modified too shortly before it was
+// hashed is hashed again, as it might have been rewritten since without its
+// mtime changing.
+func TestCacheRacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "a")
+	c := NewCache()
+
+	info := cachedfile(t, p, "hello", time.Now())
+	c.Store(p, info, testkey("hello"))
+	if _, ok := c.Lookup(p, info, SHA256); ok {
+		t.Error("trusted a file modified as it was hashed")
+	}
+	// the same file hashed again once it settled is trusted
+	info = cachedfile(t, p, "hello", time.Now().Add(-RacyWindow-time.Second))
+	c.Store(p, info, testkey("hello"))
+	if _, ok := c.Lookup(p, info, SHA256); !ok {
+		t.Error("didn't trust a file hashed after it settled")
+	}
+}
+
+func TestCacheSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	then := time.Now().Add(-time.Hour)
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	infoA := cachedfile(t, a, "hello", then)
+	infoB := cachedfile(t, b, "world", then)
+	db := filepath.Join(dir, "cache")
+
+	c := NewCache()
+	c.Store(a, infoA, testkey("a"))
+	c.Store(b, infoB, testkey("b"))
+	if err := c.Save(db); err != nil {
+		t.Fatal(err)
+	}
+	c, err = LoadCache(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := c.Lookup(a, infoA, SHA256); !ok || key != testkey("a") {
+		t.Errorf("a is %s %v after loading", key, ok)
+	}
+	// b wasn't looked up so it drops out
+	if err := c.Save(db); err != nil {
+		t.Fatal(err)
+	}
+	c, err = LoadCache(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Lookup(b, infoB, SHA256); ok {
+		t.Error("b kept although it wasn't used")
+	}
+	if _, ok := c.Lookup(a, infoA, SHA256); !ok {
+		t.Error("a dropped although it was used")
+	}
+
+	// entries of caches written before the time they were hashed was kept
+	// are verified again, broken entries are dropped
+	err = writedb.Dump(db, map[string][]string{
+		a: {"5", "0", "0", testkey("a")},
+		b: {"5", "x", "0", testkey("b"), "0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = LoadCache(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("loaded %v", c.entries)
+	}
+	if _, ok := c.Lookup(a, infoA, SHA256); ok {
+		t.Error("trusted an entry without the time it was hashed")
+	}
+
+	if c, err := LoadCache(filepath.Join(dir, "missing")); err != nil || len(c.entries) != 0 {
+		t.Errorf("missing cache gives %v, %v", c.entries, err)
+	}
+}
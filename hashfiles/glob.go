@@ -0,0 +1,74 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package hashfiles
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Glob selects slash separated paths relative to the root of a snapshot,
+// with the syntax of a Rule: * matches anything but a slash, ? matches one
+// character, [abc] matches a character class and ** matches any number of
+// directories. A glob always matches from the root, and a path matches if
+// it or one of the directories it is in does, so "DCIM/2018" selects the
+// same files as "DCIM/2018/**".
+type Glob struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// ParseGlob parses the glob pattern. A leading or trailing slash is
+// ignored.
+func ParseGlob(pattern string) (*Glob, error) {
+	pattern = strings.Trim(pattern, "/")
+	re, err := compileGlob(pattern, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Glob{pattern: pattern, re: re}, nil
+}
+
+// String returns the pattern of the glob.
+func (g *Glob) String() string {
+	return g.pattern
+}
+
+// Match reports whether the slash separated path p or one of the
+// directories it is in matches the glob.
+func (g *Glob) Match(p string) bool {
+	for p = strings.Trim(p, "/"); p != "." && p != ""; p = path.Dir(p) {
+		if g.re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchAny reports whether the path p matches any of globs.
+func MatchAny(globs []*Glob, p string) bool {
+	for _, g := range globs {
+		if g.Match(p) {
+			return true
+		}
+	}
+	return false
+}
@@ -20,47 +20,322 @@
 package hashfiles
 
 import (
-	"crypto/sha256"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
 )
 
-var files = make(map[string][sha256.Size]byte)
-var count int
+// Workers is the number of files Scan hashes at the same time unless told
+// otherwise.
+var Workers = runtime.NumCPU()
 
-func hash(path string, info os.FileInfo, err error) error {
-	if err != nil {
-		log.Print(err)
+// Scanner hashes the files below a directory. A Scanner keeps no state
+// between calls to Scan, so it can be reused and several can run at the same
+// time.
+type Scanner struct {
+	// Root is the directory to scan.
+	Root string
+	// Filter, if set, is called for every file and directory below Root.
+	// Returning false skips the file, or the whole directory.
+	Filter func(path string, info os.FileInfo) bool
+	// Workers is the number of files hashed at the same time. If it is
+	// less than one the package level Workers is used.
+	Workers int
+	// Progress, if set, is called each time a file has been hashed with the
+	// number of files hashed so far.
+	Progress func(count int, path string)
+	// Cache, if set, supplies the hashes of files that haven't changed since
+	// they were last hashed and is updated with the ones that had to be
+	// read.
+	Cache *Cache
+	// Algorithm is the hash used, SHA256 if it is empty.
+	Algorithm Algorithm
+	// Rules are gitignore style rules of paths to skip, see Rule. They are
+	// applied along with the rules of the IgnoreFile files found while
+	// scanning, which take precedence.
+	Rules []*Rule
+}
+
+// Result is what a Scanner found.
+type Result struct {
+	// Files maps the path of each file to the key of its contents, see
+	// Algorithm.Key.
+	Files map[string]string
+	// Info holds the os.FileInfo of each file in Files, as found by the
+	// scan.
+	Info map[string]os.FileInfo
+	// Dirs holds the os.FileInfo of every directory below Root, empty or
+	// not.
+	Dirs map[string]os.FileInfo
+	// Links maps the path of each symbolic link to its target. Links are
+	// kept as links, they aren't followed.
+	Links map[string]string
+	// HardLinks maps the path of each file that is a hard link to a file
+	// found before it to that file. Both are in Files, the contents are only
+	// hashed once.
+	HardLinks map[string]string
+	// Fifos holds the os.FileInfo of each named pipe.
+	Fifos map[string]os.FileInfo
+	// Skipped lists the devices and sockets found, sorted. They can't be
+	// kept in a snapshot.
+	Skipped []string
+	// Errors holds a *FileError for each file that couldn't be scanned,
+	// sorted by path.
+	Errors []error
+	// Rules are the rules the scan applied, lowest precedence first.
+	Rules []*Rule
+}
+
+// FileError records a file that could not be scanned.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+// result is the outcome of hashing one file, or an entry of the tree that
+// isn't a regular file.
+type result struct {
+	path   string
+	info   os.FileInfo
+	key    string
+	target string
+	link   string
+	err    error
+}
+
+// file is a file found by walk.
+type file struct {
+	path string
+	info os.FileInfo
+}
+
+// walk sends every regular file below Root that passes the filter and isn't
+// ignored to paths. Directories, links and other entries that aren't hashed
+// are sent straight to results, as are errors met while walking. The rules
+// applied are returned once the walk is done.
+func (s *Scanner) walk(paths chan<- file, results chan<- result, applied chan<- []*Rule) {
+	rules := append([]*Rule(nil), s.Rules...)
+	// the first path found of each file with more than one link
+	leaders := make(map[[2]uint64]string)
+	filepath.Walk(s.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			results <- result{path: path, err: err}
+			return nil
+		}
+		rel, _ := filepath.Rel(s.Root, path)
+		rel = filepath.ToSlash(rel)
+		if path != s.Root && Ignored(rules, rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if s.Filter != nil && path != s.Root && !s.Filter(path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			// rules found in a directory apply to everything below it
+			if rel == "." {
+				rel = ""
+			}
+			found, err := loadRules(filepath.Join(path, IgnoreFile), rel)
+			if err != nil {
+				results <- result{path: filepath.Join(path, IgnoreFile), err: err}
+			}
+			rules = append(rules, found...)
+			if path != s.Root {
+				results <- result{path: path, info: info}
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			results <- result{path: path, info: info, target: target, err: err}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			results <- result{path: path, info: info}
+			return nil
+		}
+		if dev, ino, nlink := fileid(info); nlink > 1 {
+			id := [2]uint64{dev, ino}
+			if leader, ok := leaders[id]; ok {
+				results <- result{path: path, info: info, link: leader}
+				return nil
+			}
+			leaders[id] = path
+		}
+		paths <- file{path: path, info: info}
 		return nil
+	})
+	close(paths)
+	applied <- rules
+}
+
+// loadRules reads the IgnoreFile at path, if there is one.
+func loadRules(path string, base string) ([]*Rule, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
-	if info.IsDir() {
-		return nil
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseRules(base, file)
+}
+
+// hash hashes the files it receives from paths, unless the cache already
+// knows their hash.
+func (s *Scanner) hash(paths <-chan file, results chan<- result) {
+	alg := s.Algorithm
+	if alg == "" {
+		alg = SHA256
 	}
+	for f := range paths {
+		if s.Cache != nil {
+			if key, ok := s.Cache.Lookup(f.path, f.info, alg); ok {
+				results <- result{path: f.path, info: f.info, key: key}
+				continue
+			}
+		}
+		key, err := HashFile(f.path, alg)
+		if err == nil && s.Cache != nil {
+			s.Cache.Store(f.path, f.info, key)
+		}
+		results <- result{path: f.path, info: f.info, key: key, err: err}
+	}
+}
 
-	data, err := ioutil.ReadFile(path)
+// HashFile returns the key of the file at path hashed with alg. The file is
+// streamed through the hash so memory use doesn't depend on the size of the
+// file.
+func HashFile(path string, alg Algorithm) (string, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Print(err)
-		return nil
+		return "", err
+	}
+	defer file.Close()
+
+	h := alg.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return alg.Key(h.Sum(nil)), nil
+}
+
+// Scan walks Root and hashes every file in it that isn't ignored by Rules or
+// an IgnoreFile. One goroutine walks the tree and feeds the files to Workers
+// goroutines that hash them. Symbolic links are recorded rather than
+// followed and files with several hard links are only hashed once. Files
+// that can't be read are returned as errors instead of stopping the scan.
+func (s *Scanner) Scan() *Result {
+	workers := s.Workers
+	if workers < 1 {
+		workers = Workers
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	paths := make(chan file, workers)
+	results := make(chan result, workers)
+
+	applied := make(chan []*Rule, 1)
+	go s.walk(paths, results, applied)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			s.hash(paths, results)
+			wg.Done()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	res := &Result{
+		Files:     make(map[string]string),
+		Info:      make(map[string]os.FileInfo),
+		Dirs:      make(map[string]os.FileInfo),
+		Links:     make(map[string]string),
+		HardLinks: make(map[string]string),
+		Fifos:     make(map[string]os.FileInfo),
+	}
+	var failed []*FileError
+	for r := range results {
+		if r.err != nil {
+			failed = append(failed, &FileError{Path: r.path, Err: r.err})
+			continue
+		}
+		switch mode := r.info.Mode(); {
+		case mode.IsDir():
+			res.Dirs[r.path] = r.info
+		case mode&os.ModeSymlink != 0:
+			res.Links[r.path] = r.target
+		case mode&os.ModeNamedPipe != 0:
+			res.Fifos[r.path] = r.info
+		case !mode.IsRegular():
+			res.Skipped = append(res.Skipped, r.path)
+		case r.link != "":
+			res.HardLinks[r.path] = r.link
+			res.Info[r.path] = r.info
+		default:
+			res.Files[r.path] = r.key
+			res.Info[r.path] = r.info
+			if s.Progress != nil {
+				s.Progress(len(res.Files), r.path)
+			}
+		}
+	}
+
+	// hard links share the hash of the file they link to
+	for file, leader := range res.HardLinks {
+		key, ok := res.Files[leader]
+		if !ok {
+			failed = append(failed, &FileError{Path: file, Err: errors.New("hard link to " + leader + " which couldn't be read")})
+			delete(res.HardLinks, file)
+			delete(res.Info, file)
+			continue
+		}
+		res.Files[file] = key
 	}
-	digest := sha256.Sum256(data)
-	files[path] = digest
-	fmt.Printf("\rScanning files: %d", count)
-	count++
 
-	return nil
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Path < failed[j].Path })
+	sort.Strings(res.Skipped)
+	res.Rules = <-applied
+	for _, e := range failed {
+		res.Errors = append(res.Errors, e)
+	}
+	return res
 }
 
 // Scan will scan all files in a directory tree and return a map of the entire
-// tree. in the form filepath => sha256byte
-func Scan(path string) map[string][sha256.Size]byte {
-	dir := path
-	err := filepath.Walk(dir, hash)
-	fmt.Println("")
-	if err != nil {
-		log.Fatal(err)
+// tree. in the form filepath => sha256 key
+// Progress is printed to stdout. Files that can't be read are returned as
+// errors, sorted by path.
+func Scan(path string, workers int) (map[string]string, []error) {
+	s := &Scanner{
+		Root:    path,
+		Workers: workers,
+		Progress: func(count int, path string) {
+			fmt.Printf("\rScanning files: %d", count)
+		},
 	}
-	return files
+	res := s.Scan()
+	fmt.Println("")
+	return res.Files, res.Errors
 }
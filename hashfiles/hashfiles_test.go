@@ -0,0 +1,197 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package hashfiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+// scantree writes n files spread over a few directories below a new
+// directory, which the caller removes.
+func scantree(t *testing.T, n int) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "hashfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for i := 0; i < n; i++ {
+		files[fmt.Sprintf("d%d/e%d/f%d", i%3, i%5, i)] = fmt.Sprint("file ", i%7)
+	}
+	writefiles(t, dir, files)
+	return dir
+}
+
+// hashed returns the keys of the files below dir, hashed one by one.
+func hashed(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		files[p], err = HashFile(p, SHA256)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestScanWorkers checks that the result doesn't depend on the number of
+// workers.
+func TestScanWorkers(t *testing.T) {
+	dir := scantree(t, 100)
+	defer os.RemoveAll(dir)
+	want := hashed(t, dir)
+	for _, workers := range []int{1, 2, 8, 64, 0, -1} {
+		count := 0
+		s := &Scanner{
+			Root:     dir,
+			Workers:  workers,
+			Progress: func(n int, path string) { count++ },
+		}
+		res := s.Scan()
+		if len(res.Errors) != 0 {
+			t.Fatalf("%d workers: %v", workers, res.Errors)
+		}
+		if !reflect.DeepEqual(res.Files, want) {
+			t.Errorf("%d workers found %d files, want %d", workers, len(res.Files), len(want))
+		}
+		if len(res.Dirs) != 3+3*5 {
+			t.Errorf("%d workers found %d directories", workers, len(res.Dirs))
+		}
+		if count != len(want) {
+			t.Errorf("%d workers reported progress %d times", workers, count)
+		}
+	}
+}
+
+// TestScanErrors checks that files that can't be scanned are collected,
+// sorted by path, without stopping the scan.
+func TestScanErrors(t *testing.T) {
+	dir := scantree(t, 20)
+	defer os.RemoveAll(dir)
+	// a character class that can't be compiled makes an IgnoreFile fail
+	writefiles(t, dir, map[string]string{
+		"d2/" + IgnoreFile:    "[z-a]\n",
+		"d0/e3/" + IgnoreFile: "[z-a]\n",
+		"d1/" + IgnoreFile:    "[z-a]\n",
+	})
+	want := []string{
+		filepath.Join(dir, "d0", "e3", IgnoreFile),
+		filepath.Join(dir, "d1", IgnoreFile),
+		filepath.Join(dir, "d2", IgnoreFile),
+	}
+	// as root every file can be read
+	if runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		locked := filepath.Join(dir, "d1", "e1", "f1")
+		if err := os.Chmod(locked, 0); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, locked)
+		sort.Strings(want)
+	}
+	for _, workers := range []int{1, 8} {
+		res := (&Scanner{Root: dir, Workers: workers}).Scan()
+		var got []string
+		for _, err := range res.Errors {
+			fe, ok := err.(*FileError)
+			if !ok {
+				t.Fatalf("%T isn't a *FileError", err)
+			}
+			got = append(got, fe.Path)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d workers give errors for %q, want %q", workers, got, want)
+		}
+		// everything else is still scanned
+		if len(res.Files)+len(want)-3 != 20+3 {
+			t.Errorf("%d workers found %d files", workers, len(res.Files))
+		}
+	}
+}
+
+// TestScanAgain checks that a Scanner used twice gives a fresh result each
+// time, without the files or rules of the scan before.
+func TestScanAgain(t *testing.T) {
+	dir := scantree(t, 10)
+	defer os.RemoveAll(dir)
+	writefiles(t, dir, map[string]string{"d0/" + IgnoreFile: "*.tmp\n"})
+	s := &Scanner{Root: dir, Rules: rules(t, "", "*.bak")}
+	first := s.Scan()
+	want := hashed(t, dir)
+	if !reflect.DeepEqual(first.Files, want) {
+		t.Fatalf("first scan found %d files, want %d", len(first.Files), len(want))
+	}
+
+	removed := filepath.Join(dir, "d1", "e1", "f1")
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	writefiles(t, dir, map[string]string{"d2/new": "new"})
+	second := s.Scan()
+	if !reflect.DeepEqual(second.Files, hashed(t, dir)) {
+		t.Errorf("second scan found %d files, want %d", len(second.Files), len(hashed(t, dir)))
+	}
+	if _, ok := second.Files[removed]; ok {
+		t.Error("second scan kept a file removed since the first")
+	}
+	if !reflect.DeepEqual(first.Files, want) {
+		t.Error("second scan changed the result of the first")
+	}
+	if len(s.Rules) != 1 || len(first.Rules) != 2 || len(second.Rules) != 2 {
+		t.Errorf("rules grew to %d, %d and %d", len(s.Rules), len(first.Rules), len(second.Rules))
+	}
+}
+
+// TestScanConcurrent runs scans of one Scanner and of another at the same
+// time, which go test -race checks for shared state.
+func TestScanConcurrent(t *testing.T) {
+	a := scantree(t, 30)
+	defer os.RemoveAll(a)
+	b := scantree(t, 40)
+	defer os.RemoveAll(b)
+	wantA, wantB := hashed(t, a), hashed(t, b)
+	sa := &Scanner{Root: a, Workers: 4, Cache: NewCache()}
+	sb := &Scanner{Root: b, Workers: 4}
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			done <- reflect.DeepEqual(sa.Scan().Files, wantA)
+		}()
+		go func() {
+			done <- reflect.DeepEqual(sb.Scan().Files, wantB)
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if !<-done {
+			t.Error("a scan run alongside others found other files")
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package hashfiles
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of the files holding the rules of which files to
+// skip. The rules in an IgnoreFile apply to the directory it is in and
+// everything below it.
+const IgnoreFile = ".hashtreeignore"
+
+// Rule is a gitignore style pattern of paths to skip. Patterns are made of
+// names separated by slashes, where * matches anything but a slash, ? matches
+// one character, [abc] matches a character class and ** matches any number
+// of directories. A pattern without a slash matches the name of a file or
+// directory at any depth, one with a slash is matched against the whole path.
+// A trailing slash only matches directories, a leading ! re-includes paths an
+// earlier rule skipped.
+type Rule struct {
+	// Pattern is the rule rewritten relative to the root of the scan.
+	Pattern string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// ParseRule parses one line of an IgnoreFile found in the directory base,
+// relative to the root of the scan and slash separated, "" for the root
+// itself. Blank lines and comments give a nil Rule.
+func ParseRule(base string, line string) (*Rule, error) {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasSuffix(line, "\\ ") {
+		line = strings.TrimRight(line, " \t")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	r := &Rule{}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, nil
+	}
+	// a pattern without a slash in it matches at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimLeft(line, "/")
+	base = strings.Trim(base, "/")
+	if base != "" {
+		if !anchored {
+			line = "**/" + line
+		}
+		line = escapeGlob(base) + "/" + line
+		anchored = true
+	}
+	re, err := compileGlob(line, anchored)
+	if err != nil {
+		return nil, err
+	}
+	r.re = re
+	r.Pattern = line
+	if anchored && !strings.Contains(line, "/") {
+		r.Pattern = "/" + line
+	} else if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+		r.Pattern = "\\" + line
+	}
+	if r.negate {
+		r.Pattern = "!" + r.Pattern
+	}
+	if r.dirOnly {
+		r.Pattern += "/"
+	}
+	return r, nil
+}
+
+// ParseRules parses the rules read from r, see ParseRule.
+func ParseRules(base string, r io.Reader) ([]*Rule, error) {
+	var rules []*Rule
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		rule, err := ParseRule(base, scanner.Text())
+		if err != nil {
+			return rules, fmt.Errorf("line %d: %v", n, err)
+		}
+		if rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// String returns the pattern of the rule, as it would be written in an
+// IgnoreFile at the root of the scan.
+func (r *Rule) String() string {
+	return r.Pattern
+}
+
+// Match reports whether the rule matches the slash separated path p,
+// relative to the root of the scan.
+func (r *Rule) Match(p string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return r.re.MatchString(p)
+}
+
+// Ignored reports whether rules skip the slash separated path p, relative to
+// the root of the scan. The last rule that matches decides.
+func Ignored(rules []*Rule, p string, isDir bool) bool {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].Match(p, isDir) {
+			return !rules[i].negate
+		}
+	}
+	return false
+}
+
+// escapeGlob escapes the characters of name that have a meaning in a glob,
+// so a directory like "photos [2018]" is matched by its name.
+func escapeGlob(name string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(name)
+}
+
+// compileGlob turns a glob into a regular expression matching whole paths. An
+// unanchored glob may match at any depth.
+func compileGlob(glob string, anchored bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				// **/ matches any number of directories, a trailing **
+				// everything below
+				if i+2 < len(glob) && glob[i+2] == '/' {
+					b.WriteString("(?:.*/)?")
+					i += 2
+				} else {
+					b.WriteString(".*")
+					i++
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := strings.IndexByte(glob[i+1:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+j]
+			b.WriteString("[")
+			if strings.HasPrefix(class, "!") {
+				b.WriteString("^")
+				class = class[1:]
+			}
+			b.WriteString(strings.NewReplacer(`\`, `\\`, `[`, `\[`).Replace(class))
+			b.WriteString("]")
+			i += j + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,203 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package hashfiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// rules parses the lines of an IgnoreFile found in base.
+func rules(t *testing.T, base string, lines ...string) []*Rule {
+	t.Helper()
+	rules, err := ParseRules(base, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestIgnored(t *testing.T) {
+	tests := []struct {
+		rules   []string
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		// without a slash a pattern matches a name at any depth
+		{[]string{"*.tmp"}, "a.tmp", false, true},
+		{[]string{"*.tmp"}, "x/y/a.tmp", false, true},
+		{[]string{"*.tmp"}, "a.tmp.jpg", false, false},
+		{[]string{"cache"}, "x/cache", true, true},
+		{[]string{"?.txt"}, "a.txt", false, true},
+		{[]string{"?.txt"}, "ab.txt", false, false},
+		{[]string{"[ab].txt"}, "b.txt", false, true},
+		{[]string{"[!ab].txt"}, "b.txt", false, false},
+		// with a slash it is matched against the whole path
+		{[]string{"/a.tmp"}, "a.tmp", false, true},
+		{[]string{"/a.tmp"}, "x/a.tmp", false, false},
+		{[]string{"x/*.tmp"}, "x/a.tmp", false, true},
+		{[]string{"x/*.tmp"}, "y/x/a.tmp", false, false},
+		{[]string{"x/*.tmp"}, "x/y/a.tmp", false, false},
+		// ** matches any number of directories
+		{[]string{"**/cache"}, "cache", true, true},
+		{[]string{"**/cache"}, "x/y/cache", true, true},
+		{[]string{"x/**/a.tmp"}, "x/a.tmp", false, true},
+		{[]string{"x/**/a.tmp"}, "x/y/z/a.tmp", false, true},
+		{[]string{"x/**"}, "x/y/z", false, true},
+		{[]string{"x/**"}, "y/x/z", false, false},
+		// a trailing slash only matches directories
+		{[]string{"cache/"}, "cache", true, true},
+		{[]string{"cache/"}, "cache", false, false},
+		// the last rule that matches decides
+		{[]string{"*.tmp", "!keep.tmp"}, "keep.tmp", false, false},
+		{[]string{"*.tmp", "!keep.tmp"}, "a.tmp", false, true},
+		{[]string{"!keep.tmp", "*.tmp"}, "keep.tmp", false, true},
+		// escapes, comments and blank lines
+		{[]string{`\!a`}, "!a", false, true},
+		{[]string{`\#a`}, "#a", false, true},
+		{[]string{"#a"}, "#a", false, false},
+		{[]string{"", "  ", "a.tmp  "}, "a.tmp", false, true},
+		{[]string{`\*`}, "a", false, false},
+		{[]string{`\*`}, "*", false, true},
+	}
+	for _, test := range tests {
+		got := Ignored(rules(t, "", test.rules...), test.path, test.isDir)
+		if got != test.ignored {
+			t.Errorf("%q ignores %s: %v, want %v", test.rules, test.path, got, test.ignored)
+		}
+	}
+}
+
+// TestIgnoredNested checks that the rules of an IgnoreFile below the root
+// only apply below the directory it is in, even when its name is a glob.
+func TestIgnoredNested(t *testing.T) {
+	tests := []struct {
+		base    string
+		rule    string
+		path    string
+		ignored bool
+		pattern string
+	}{
+		{"x", "*.tmp", "x/a.tmp", true, "x/**/*.tmp"},
+		{"x", "*.tmp", "x/y/a.tmp", true, "x/**/*.tmp"},
+		{"x", "*.tmp", "a.tmp", false, "x/**/*.tmp"},
+		{"x", "*.tmp", "y/a.tmp", false, "x/**/*.tmp"},
+		{"x", "/a.tmp", "x/a.tmp", true, "x/a.tmp"},
+		{"x", "/a.tmp", "x/y/a.tmp", false, "x/a.tmp"},
+		{"x/y", "z/a.tmp", "x/y/z/a.tmp", true, "x/y/z/a.tmp"},
+		{"photos [2018]", "*.tmp", "photos [2018]/a.tmp", true, `photos \[2018\]/**/*.tmp`},
+		{"photos [2018]", "*.tmp", "photos 2/a.tmp", false, `photos \[2018\]/**/*.tmp`},
+		{"what?", "*.tmp", "what?/a.tmp", true, `what\?/**/*.tmp`},
+		{"what?", "*.tmp", "whats/a.tmp", false, `what\?/**/*.tmp`},
+		{"*", "a.tmp", "*/a.tmp", true, `\*/**/a.tmp`},
+		{"*", "a.tmp", "x/a.tmp", false, `\*/**/a.tmp`},
+		{`back\slash`, "a.tmp", `back\slash/a.tmp`, true, `back\\slash/**/a.tmp`},
+	}
+	for _, test := range tests {
+		r := rules(t, test.base, test.rule)
+		if got := Ignored(r, test.path, false); got != test.ignored {
+			t.Errorf("%s in %s ignores %s: %v, want %v", test.rule, test.base, test.path, got, test.ignored)
+		}
+		if got := r[0].String(); got != test.pattern {
+			t.Errorf("%s in %s is written %s, want %s", test.rule, test.base, got, test.pattern)
+		}
+		// the pattern as written means the same at the root
+		if got := Ignored(rules(t, "", r[0].String()), test.path, false); got != test.ignored {
+			t.Errorf("%s ignores %s: %v, want %v", r[0], test.path, got, test.ignored)
+		}
+	}
+}
+
+// TestScanIgnore checks the rules of the IgnoreFile files found by Scan,
+// with a nested file re-including what one above it skips.
+func TestScanIgnore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writefiles(t, dir, map[string]string{
+		IgnoreFile:                    "*.tmp\ncache/\n",
+		"a.tmp":                       "",
+		"a.txt":                       "",
+		"cache/a.txt":                 "",
+		"x/cache":                     "a file, not a directory",
+		"x/" + IgnoreFile:             "!keep.tmp\n",
+		"x/keep.tmp":                  "",
+		"x/b.tmp":                     "",
+		"photos [2018]/" + IgnoreFile: "*.jpg\n",
+		"photos [2018]/a.jpg":         "",
+		"photos [2018]/b.png":         "",
+		"photos 2/a.jpg":              "",
+	})
+	res := (&Scanner{Root: dir}).Scan()
+	if len(res.Errors) != 0 {
+		t.Fatal(res.Errors)
+	}
+	want := []string{
+		IgnoreFile,
+		"a.txt",
+		"photos 2/a.jpg",
+		"photos [2018]/" + IgnoreFile,
+		"photos [2018]/b.png",
+		"x/" + IgnoreFile,
+		"x/cache",
+		"x/keep.tmp",
+	}
+	if got := relpaths(t, dir, res.Files); !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned %q, want %q", got, want)
+	}
+}
+
+// writefiles writes files below dir.
+func writefiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// relpaths returns the paths of files relative to dir, slash separated and
+// sorted.
+func relpaths(t *testing.T, dir string, files map[string]string) []string {
+	t.Helper()
+	var paths []string
+	for p := range files {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	sort.Strings(paths)
+	return paths
+}
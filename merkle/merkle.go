@@ -0,0 +1,184 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package merkle
+
+import (
+	"fmt"
+	"hashtree-mobile/hashfiles"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Node is a file or a directory of a snapshot. The hash of a directory is
+// computed from the names, kinds and hashes of its entries, so the hash of
+// the root identifies the whole snapshot and two trees only differ below
+// directories whose hashes differ.
+type Node struct {
+	// Name is the last element of the path of the node, empty for the root.
+	Name string
+	// Hash is the key of a file's contents or of a directory's entries.
+	Hash string
+	// Kind is the kind of an entry that is neither a file nor a directory,
+	// such as "symlink", "" otherwise.
+	Kind string
+	// Children holds the entries of a directory by name. It is nil for
+	// files.
+	Children map[string]*Node
+}
+
+// IsDir reports whether n is a directory.
+func (n *Node) IsDir() bool {
+	return n.Children != nil
+}
+
+// Names returns the names of the entries of a directory, sorted.
+func (n *Node) Names() []string {
+	names := make([]string, 0, len(n.Children))
+	for name := range n.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the node at the slash separated path p below n, or nil if
+// there is none.
+func (n *Node) Lookup(p string) *Node {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return n
+	}
+	for _, name := range strings.Split(p, "/") {
+		if n = n.Children[name]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// New returns an empty tree.
+func New() *Node {
+	return &Node{Children: make(map[string]*Node)}
+}
+
+// Build returns the tree of a snapshot in the format hash => relative paths,
+// with the directories hashed with alg.
+func Build(snapshot map[string][]string, alg hashfiles.Algorithm) *Node {
+	root := New()
+	for hash, paths := range snapshot {
+		for _, p := range paths {
+			root.Add(p, &Node{Hash: hash})
+		}
+	}
+	root.Rehash(alg)
+	return root
+}
+
+// Add adds node at the slash separated path p below n, creating the
+// directories leading to it. A directory wins over a file of the same name,
+// whatever order they are added in. The hashes of the directories are stale
+// until Rehash is called.
+func (n *Node) Add(p string, node *Node) {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return
+	}
+	names := strings.Split(p, "/")
+	for _, name := range names[:len(names)-1] {
+		child := n.Children[name]
+		if child == nil || !child.IsDir() {
+			child = &Node{Name: name, Children: make(map[string]*Node)}
+			n.Children[name] = child
+		}
+		n = child
+	}
+	name := names[len(names)-1]
+	if child := n.Children[name]; child == nil || !child.IsDir() {
+		node.Name = name
+		n.Children[name] = node
+	}
+}
+
+// Rehash computes the hashes of n and all directories below it. Entries are
+// written in order of their names, each as kind, hash and length prefixed
+// name, so no two different directories hash the same input.
+func (n *Node) Rehash(alg hashfiles.Algorithm) {
+	if !n.IsDir() {
+		return
+	}
+	h := alg.New()
+	for _, name := range n.Names() {
+		child := n.Children[name]
+		child.Rehash(alg)
+		kind := "f"
+		if child.IsDir() {
+			kind = "d"
+		} else if child.Kind != "" {
+			kind = child.Kind
+		}
+		fmt.Fprintf(h, "%s %s %d:%s\n", kind, child.Hash, len(name), name)
+	}
+	n.Hash = alg.Key(h.Sum(nil))
+}
+
+// Change is a path that differs between two trees. A is nil if the path was
+// added in b, B is nil if it was removed.
+type Change struct {
+	Path string
+	A, B *Node
+}
+
+// Compare returns the paths that differ between a and b, sorted. Only
+// directories whose hashes differ are walked, and a directory that exists in
+// one tree only is reported once instead of file by file.
+func Compare(a, b *Node) []Change {
+	var changes []Change
+	compare("", a, b, &changes)
+	return changes
+}
+
+func compare(p string, a, b *Node, changes *[]Change) {
+	if a.Hash == b.Hash && a.IsDir() == b.IsDir() && a.Kind == b.Kind {
+		return
+	}
+	if !a.IsDir() || !b.IsDir() {
+		*changes = append(*changes, Change{Path: p, A: a, B: b})
+		return
+	}
+	names := a.Names()
+	for _, name := range b.Names() {
+		if a.Children[name] == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ca, cb := a.Children[name], b.Children[name]
+		switch {
+		case ca == nil:
+			*changes = append(*changes, Change{Path: path.Join(p, name), B: cb})
+		case cb == nil:
+			*changes = append(*changes, Change{Path: path.Join(p, name), A: ca})
+		default:
+			compare(path.Join(p, name), ca, cb, changes)
+		}
+	}
+}
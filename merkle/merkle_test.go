@@ -0,0 +1,197 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package merkle
+
+import (
+	"hashtree-mobile/hashfiles"
+	"reflect"
+	"testing"
+)
+
+// files is a snapshot of a few files in nested directories.
+var files = map[string]string{
+	"a.txt":        "h1",
+	"notes/todo":   "h2",
+	"notes/copy":   "h1",
+	"DCIM/x/1.jpg": "h3",
+	"DCIM/x/2.jpg": "h4",
+	"DCIM/y/1.jpg": "h5",
+}
+
+// build returns the tree of files added in the order of paths.
+func build(files map[string]string, paths []string) *Node {
+	root := New()
+	for _, p := range paths {
+		root.Add(p, &Node{Hash: files[p]})
+	}
+	root.Rehash(hashfiles.SHA256)
+	return root
+}
+
+func TestRehashOrder(t *testing.T) {
+	paths := []string{"a.txt", "notes/todo", "notes/copy", "DCIM/x/1.jpg", "DCIM/x/2.jpg", "DCIM/y/1.jpg"}
+	want := build(files, paths).Hash
+	reversed := make([]string, len(paths))
+	for i, p := range paths {
+		reversed[len(paths)-1-i] = p
+	}
+	if got := build(files, reversed).Hash; got != want {
+		t.Errorf("hash %s in reverse order, %s in order", got, want)
+	}
+	// Build ranges over a map, in a different order every time
+	for i := 0; i < 10; i++ {
+		snapshot := make(map[string][]string)
+		for p, hash := range files {
+			snapshot[hash] = append(snapshot[hash], p)
+		}
+		if got := Build(snapshot, hashfiles.SHA256).Hash; got != want {
+			t.Fatalf("Build hashes %s, want %s", got, want)
+		}
+	}
+	// another algorithm gives another hash
+	root := build(files, paths)
+	root.Rehash(hashfiles.BLAKE3)
+	if root.Hash == want {
+		t.Error("the hash doesn't depend on the algorithm")
+	}
+}
+
+func TestRehashChange(t *testing.T) {
+	paths := []string{"a.txt", "notes/todo", "notes/copy", "DCIM/x/1.jpg", "DCIM/x/2.jpg", "DCIM/y/1.jpg"}
+	before := build(files, paths)
+	changed := make(map[string]string)
+	for p, hash := range files {
+		changed[p] = hash
+	}
+	changed["DCIM/x/2.jpg"] = "h6"
+	after := build(changed, paths)
+
+	for _, p := range []string{"", "DCIM", "DCIM/x", "DCIM/x/2.jpg"} {
+		if before.Lookup(p).Hash == after.Lookup(p).Hash {
+			t.Errorf("%q kept its hash", p)
+		}
+	}
+	for _, p := range []string{"a.txt", "notes", "notes/todo", "DCIM/y", "DCIM/x/1.jpg"} {
+		if before.Lookup(p).Hash != after.Lookup(p).Hash {
+			t.Errorf("%q changed its hash", p)
+		}
+	}
+
+	// names and kinds count as well as contents
+	renamed := build(map[string]string{"b.txt": "h1"}, []string{"b.txt"})
+	link := New()
+	link.Add("a.txt", &Node{Hash: "h1", Kind: hashfiles.TypeSymlink})
+	link.Rehash(hashfiles.SHA256)
+	plain := build(map[string]string{"a.txt": "h1"}, []string{"a.txt"})
+	if renamed.Hash == plain.Hash || link.Hash == plain.Hash {
+		t.Error("a renamed file or a link hashes like the file")
+	}
+}
+
+func TestAddFileAndDir(t *testing.T) {
+	fileFirst := New()
+	fileFirst.Add("x", &Node{Hash: "h1"})
+	fileFirst.Add("x/y", &Node{Hash: "h2"})
+	dirFirst := New()
+	dirFirst.Add("x/y", &Node{Hash: "h2"})
+	dirFirst.Add("x", &Node{Hash: "h1"})
+	for _, root := range []*Node{fileFirst, dirFirst} {
+		x := root.Lookup("x")
+		if x == nil || !x.IsDir() {
+			t.Fatalf("x is %+v, want a directory", x)
+		}
+		if y := root.Lookup("x/y"); y == nil || y.Hash != "h2" || y.Name != "y" {
+			t.Errorf("x/y is %+v", y)
+		}
+	}
+	fileFirst.Rehash(hashfiles.SHA256)
+	dirFirst.Rehash(hashfiles.SHA256)
+	if fileFirst.Hash != dirFirst.Hash {
+		t.Error("the order of a file and a directory of the same name matters")
+	}
+
+	// paths are cleaned and the root can't be replaced
+	root := New()
+	root.Add("/a//b/../c", &Node{Hash: "h1"})
+	root.Add("", &Node{Hash: "h2"})
+	if c := root.Lookup("a/c"); c == nil || c.Hash != "h1" {
+		t.Errorf("a/c is %+v", c)
+	}
+	if !root.IsDir() || len(root.Children) != 1 {
+		t.Errorf("root is %+v", root)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	paths := []string{"a.txt", "notes/todo", "notes/copy", "DCIM/x/1.jpg", "DCIM/x/2.jpg", "DCIM/y/1.jpg"}
+	a := build(files, paths)
+	changed := map[string]string{
+		"a.txt":        "h7",
+		"notes/todo":   "h2",
+		"notes/copy":   "h1",
+		"DCIM/x/1.jpg": "h3",
+		"DCIM/x/2.jpg": "h4",
+		"new/a":        "h8",
+		"new/b":        "h9",
+	}
+	b := build(changed, []string{"a.txt", "notes/todo", "notes/copy", "DCIM/x/1.jpg", "DCIM/x/2.jpg", "new/a", "new/b"})
+
+	var got []string
+	for _, c := range Compare(a, b) {
+		got = append(got, c.Path)
+		switch c.Path {
+		case "DCIM/y":
+			if c.A == nil || c.B != nil {
+				t.Errorf("removed %s is %+v", c.Path, c)
+			}
+		case "new":
+			if c.A != nil || c.B == nil {
+				t.Errorf("added %s is %+v", c.Path, c)
+			}
+		case "a.txt":
+			if c.A.Hash != "h1" || c.B.Hash != "h7" {
+				t.Errorf("changed %s is %+v", c.Path, c)
+			}
+		}
+	}
+	want := []string{"DCIM/y", "a.txt", "new"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes %v, want %v", got, want)
+	}
+	if changes := Compare(a, a); len(changes) != 0 {
+		t.Errorf("a tree differs from itself: %v", changes)
+	}
+
+	// subtrees with the same hash aren't walked, so a difference hidden
+	// below one isn't found
+	b = build(files, paths)
+	b.Lookup("notes").Children["todo"].Hash = "h9"
+	if changes := Compare(a, b); len(changes) != 0 {
+		t.Errorf("walked an identical subtree: %v", changes)
+	}
+
+	// a file replaced by a directory is one change
+	b = build(map[string]string{"a.txt/x": "h1"}, []string{"a.txt/x"})
+	a = build(map[string]string{"a.txt": "h1"}, []string{"a.txt"})
+	changes := Compare(a, b)
+	if len(changes) != 1 || changes[0].Path != "a.txt" || changes[0].A.IsDir() || !changes[0].B.IsDir() {
+		t.Errorf("file replaced by a directory gives %+v", changes)
+	}
+}
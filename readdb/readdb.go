@@ -21,14 +21,228 @@ package readdb
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"hash"
+	"io"
 	"os"
-	"regexp"
 	"strings"
 )
 
-// Load reads a simple YAML file with a map structure of:
+// Header is the first line of a database in the current format. Databases
+// start with a header naming their format version, then hold one line per
+// key and one per value:
+//
+//	hashtree-db 2
+//	k <key>
+//	v <value>
+//	v <value>
+//	sum <sha256 of everything above this line>
+//
+// Backslashes, newlines and carriage returns in keys and values are escaped
+// as \\, \n and \r so every entry stays on its own line. Databases without a
+// header are in the format used before, which is still read so old
+// repositories keep working; they are rewritten in the current format the
+// next time they are written.
+const Header = "hashtree-db 2"
+
+// ErrChecksum is returned for a database whose contents don't match its
+// checksum.
+var ErrChecksum = errors.New("database checksum mismatch")
+
+// SyntaxError is returned for a database with a line that can't be parsed.
+type SyntaxError struct {
+	// Line is the number of the line, starting at 1.
+	Line int
+	// Text is the line.
+	Text string
+	// Msg describes what is wrong with it.
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("database line %d: %s: %q", e.Line, e.Msg, e.Text)
+}
+
+// newline ends every line hashed into the checksum.
+var newline = []byte("\n")
+
+// Reader reads the entries of a database one at a time, so databases of any
+// size can be read without holding them in memory. Lines may be of any
+// length.
+type Reader struct {
+	r       *bufio.Reader
+	sum     hash.Hash
+	started bool
+	legacy  bool
+	// lineno is the number of the last line read
+	lineno int
+	// held is a line that was read but belongs to the next entry
+	held    string
+	hasHeld bool
+	// done is set once the end of the database was reached
+	done bool
+	err  error
+}
+
+// NewReader returns a Reader reading a database from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Next returns the next key of the database and its values, it returns
+// io.EOF after the last one. The checksum covers the whole database, so a
+// database that was tampered with is only noticed at its end: entries
+// returned before io.EOF can't be trusted yet. Keys may be returned more than
+// once by databases in the old format, their values add up. Lines that can't
+// be parsed are reported as a *SyntaxError.
+func (r *Reader) Next() (string, []string, error) {
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	if r.done {
+		r.err = io.EOF
+		return "", nil, r.err
+	}
+	if !r.started {
+		r.started = true
+		if r.err = r.start(); r.err != nil {
+			return "", nil, r.err
+		}
+	}
+	var key string
+	var values []string
+	if r.legacy {
+		key, values, r.err = r.nextLegacy()
+	} else {
+		key, values, r.err = r.nextv2()
+	}
+	return key, values, r.err
+}
+
+// start reads the header of the database to find its format.
+func (r *Reader) start() error {
+	first, ok, err := r.readline()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return io.EOF
+	}
+	if first == Header {
+		r.sum = sha256.New()
+		r.sum.Write([]byte(Header))
+		r.sum.Write(newline)
+		return nil
+	}
+	if strings.HasPrefix(first, "hashtree-db ") {
+		return r.syntax(first, "unsupported database version")
+	}
+	r.legacy = true
+	r.unread(first)
+	return nil
+}
+
+// syntax returns a *SyntaxError for the last line read.
+func (r *Reader) syntax(line string, msg string) error {
+	return &SyntaxError{Line: r.lineno, Text: line, Msg: msg}
+}
+
+// readline returns the next line of the database without its line ending,
+// false at its end.
+func (r *Reader) readline() (string, bool, error) {
+	if r.hasHeld {
+		r.hasHeld = false
+		return r.held, true, nil
+	}
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// the line doesn't fit the buffer, put it together in pieces
+		long := append([]byte(nil), line...)
+		for err == bufio.ErrBufferFull {
+			line, err = r.r.ReadSlice('\n')
+			long = append(long, line...)
+		}
+		line = long
+	}
+	if err == io.EOF {
+		if len(line) == 0 {
+			return "", false, nil
+		}
+	} else if err != nil {
+		return "", false, err
+	}
+	r.lineno++
+	n := len(line)
+	if n > 0 && line[n-1] == '\n' {
+		n--
+	}
+	if n > 0 && line[n-1] == '\r' {
+		n--
+	}
+	return string(line[:n]), true, nil
+}
+
+// unread hands line out again on the next call to readline.
+func (r *Reader) unread(line string) {
+	r.held = line
+	r.hasHeld = true
+}
+
+// nextv2 reads the next entry of a database in the current format.
+func (r *Reader) nextv2() (string, []string, error) {
+	var key string
+	var values []string
+	var haveKey bool
+	for {
+		line, ok, err := r.readline()
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
+			// a database cut short has lost its checksum
+			return "", nil, ErrChecksum
+		}
+		switch {
+		case strings.HasPrefix(line, "sum "):
+			if !checksum(r.sum, strings.TrimPrefix(line, "sum ")) {
+				return "", nil, ErrChecksum
+			}
+			extra, ok, err := r.readline()
+			if err != nil {
+				return "", nil, err
+			}
+			if ok {
+				return "", nil, r.syntax(extra, "data after the checksum")
+			}
+			if !haveKey {
+				return "", nil, io.EOF
+			}
+			r.done = true
+			return key, values, nil
+		case strings.HasPrefix(line, "k "):
+			if haveKey {
+				r.unread(line)
+				return key, values, nil
+			}
+			key = Unescape(line[2:])
+			haveKey = true
+		case strings.HasPrefix(line, "v "):
+			if !haveKey {
+				return "", nil, r.syntax(line, "value without a key")
+			}
+			values = append(values, Unescape(line[2:]))
+		default:
+			return "", nil, r.syntax(line, "not comprehensible")
+		}
+		r.sum.Write([]byte(line))
+		r.sum.Write(newline)
+	}
+}
+
+// Load reads a database with a map structure of:
 // hash -> array [ filepath, filepath ]
 func Load(path string) (map[string][]string, error) {
 	var hashmap = make(map[string][]string)
@@ -37,46 +251,86 @@ func Load(path string) (map[string][]string, error) {
 		return hashmap, err
 	}
 	defer file.Close()
+	return ReadAll(NewReader(file), hashmap)
+}
 
-	scanner := bufio.NewScanner(file)
-	var hash string
-	for scanner.Scan() {
-		matched, err := regexp.MatchString("^--- .*", scanner.Text())
+// ReadAll adds all entries read from r to hashmap.
+func ReadAll(r *Reader, hashmap map[string][]string) (map[string][]string, error) {
+	for {
+		key, values, err := r.Next()
+		if err == io.EOF {
+			return hashmap, nil
+		}
 		if err != nil {
-			fmt.Println("Error database not comprehensible!!")
 			return hashmap, err
 		}
-		if matched == true {
-			re := regexp.MustCompile("^--- ")
-			s := ""
-			hash = re.ReplaceAllString(scanner.Text(), s)
+		hashmap[key] = append(hashmap[key], values...)
+	}
+}
+
+// checksum reports whether sum matches the hex digest want.
+func checksum(sum hash.Hash, want string) bool {
+	return hex.EncodeToString(sum.Sum(nil)) == want
+}
+
+// Unescape reverses the escaping of keys and values.
+func Unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
 			continue
 		}
-		i := strings.Compare("---", scanner.Text())
-		if i == 0 {
-			continue
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
 		}
-		matched, err = regexp.MatchString("^- .*", scanner.Text())
+	}
+	return b.String()
+}
+
+// nextLegacy reads the next entry of a database in the format used before
+// the header was introduced, a simple YAML file of:
+// --- hash
+// ---
+// - filepath
+// Keys without values are skipped, as they always were.
+func (r *Reader) nextLegacy() (string, []string, error) {
+	var key string
+	var values []string
+	for {
+		line, ok, err := r.readline()
 		if err != nil {
-			fmt.Println("Error database not comprehensible!!")
-			return hashmap, err
+			return "", nil, err
 		}
-		if matched == true {
-			re := regexp.MustCompile("^- ")
-			s := ""
-			filepath := re.ReplaceAllString(scanner.Text(), s)
-			hashmap[hash] = append(hashmap[hash], filepath)
-			continue
-		} else {
-			fmt.Println("Error database not comprehensible!!")
-			return hashmap, err
+		if !ok {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "--- "):
+			if len(values) > 0 {
+				r.unread(line)
+				return key, values, nil
+			}
+			key = line[4:]
+		case line == "---":
+		case strings.HasPrefix(line, "- "):
+			values = append(values, line[2:])
+		default:
+			return "", nil, r.syntax(line, "not comprehensible")
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
-		return hashmap, err
+	if len(values) == 0 {
+		return "", nil, io.EOF
 	}
-
-	return hashmap, nil
+	r.done = true
+	return key, values, nil
 }
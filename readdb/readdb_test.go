@@ -0,0 +1,151 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package readdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// database returns a database in the current format made of lines, with
+// the checksum of the lines.
+func database(lines ...string) string {
+	body := Header + "\n"
+	for _, line := range lines {
+		body += line + "\n"
+	}
+	sum := sha256.Sum256([]byte(body))
+	return body + "sum " + hex.EncodeToString(sum[:]) + "\n"
+}
+
+// readall reads a database from s.
+func readall(s string) (map[string][]string, error) {
+	return ReadAll(NewReader(strings.NewReader(s)), make(map[string][]string))
+}
+
+func TestRead(t *testing.T) {
+	long := strings.Repeat("x", 100*1024)
+	tests := []struct {
+		name string
+		db   string
+		want map[string][]string
+	}{
+		{"empty file", "", map[string][]string{}},
+		{"empty database", database(), map[string][]string{}},
+		{"entries", database("k a", "v 1", "v 2", "k b", "k c", "v 3"),
+			map[string][]string{"a": {"1", "2"}, "b": nil, "c": {"3"}}},
+		{"escaped", database(`k new\nline`, `v back\\slash`, "v - dash", "v --- triple"),
+			map[string][]string{"new\nline": {"back\\slash", "- dash", "--- triple"}}},
+		{"long lines", database("k "+long, "v "+long+long),
+			map[string][]string{long: {long + long}}},
+		{"crlf", strings.Replace(database("k a", "v 1"), "\n", "\r\n", 2),
+			map[string][]string{"a": {"1"}}},
+		{"legacy", "--- h1\n---\n- a/b\n- c d\n--- h2\n---\n- e\n",
+			map[string][]string{"h1": {"a/b", "c d"}, "h2": {"e"}}},
+		{"legacy keys without values", "--- h1\n---\n--- h2\n---\n- e\n",
+			map[string][]string{"h2": {"e"}}},
+		{"legacy repeated keys", "--- h1\n---\n- a\n--- h2\n---\n- b\n--- h1\n---\n- c\n",
+			map[string][]string{"h1": {"a", "c"}, "h2": {"b"}}},
+		{"legacy long lines", "--- h\n---\n- " + long + "\n",
+			map[string][]string{"h": {long}}},
+	}
+	for _, test := range tests {
+		got, err := readall(test.db)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	good := database("k a", "v 1", "k b", "v 2")
+	last := "0"
+	if strings.HasSuffix(good, "0\n") {
+		last = "1"
+	}
+	tests := map[string]string{
+		"changed value":     strings.Replace(good, "v 1", "v 9", 1),
+		"dropped entry":     strings.Replace(good, "k b\nv 2\n", "", 1),
+		"changed checksum":  good[:len(good)-2] + last + "\n",
+		"missing checksum":  good[:strings.Index(good, "sum ")],
+		"cut short":         good[:strings.Index(good, "k b")],
+		"empty after start": Header + "\n",
+	}
+	for name, db := range tests {
+		if _, err := readall(db); err != ErrChecksum {
+			t.Errorf("%s: got %v, want %v", name, err, ErrChecksum)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		db   string
+		line int
+		msg  string
+	}{
+		{database("k a", "v 1", "x bad"), 4, "not comprehensible"},
+		{database("v 1"), 2, "value without a key"},
+		{database("k a") + "k b\n", 4, "data after the checksum"},
+		{"hashtree-db 3\nk a\n", 1, "unsupported database version"},
+		{"--- h\n---\n- a\nbad\n", 4, "not comprehensible"},
+	}
+	for _, test := range tests {
+		_, err := readall(test.db)
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%q: got %v, want a syntax error", test.db, err)
+			continue
+		}
+		if serr.Line != test.line || serr.Msg != test.msg {
+			t.Errorf("%q: got %v, want line %d: %s", test.db, serr, test.line, test.msg)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	r := NewReader(strings.NewReader(database("k a", "v 1", "k b")))
+	var keys []string
+	for {
+		key, _, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("got keys %q", keys)
+	}
+	// the end is sticky
+	if _, _, err := r.Next(); err != io.EOF {
+		t.Errorf("got %v after the end", err)
+	}
+}
@@ -20,37 +20,143 @@
 package writedb
 
 import (
-	"bytes"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hashtree-mobile/readdb"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
-// Dump will output a YAML style format:
+// escaper escapes the characters that would break an entry over lines.
+var escaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+
+// Escape escapes a key or value so it fits on one line, see readdb.Header.
+func Escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// Writer writes a database one entry at a time, so databases of any size can
+// be written without holding them in memory.
+type Writer struct {
+	w       *bufio.Writer
+	sum     hash.Hash
+	out     io.Writer
+	started bool
+	err     error
+}
+
+// NewWriter returns a Writer writing a database to w. The database is only
+// complete once Close was called.
+func NewWriter(w io.Writer) *Writer {
+	dw := &Writer{w: bufio.NewWriter(w), sum: sha256.New()}
+	dw.out = io.MultiWriter(dw.w, dw.sum)
+	return dw
+}
+
+// Write adds key and its values to the database.
+func (w *Writer) Write(key string, values []string) error {
+	w.start()
+	// new entries begin with the key
+	w.line("k ", key)
+	// followed by a line per value
+	for _, value := range values {
+		w.line("v ", value)
+	}
+	return w.err
+}
+
+// Close ends the database with its checksum and flushes it. It doesn't
+// close the underlying io.Writer.
+func (w *Writer) Close() error {
+	w.start()
+	if w.err != nil {
+		return w.err
+	}
+	_, w.err = io.WriteString(w.w, "sum "+hex.EncodeToString(w.sum.Sum(nil))+"\n")
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
+
+// start writes the header before the first entry.
+func (w *Writer) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	_, w.err = io.WriteString(w.out, readdb.Header+"\n")
+}
+
+// line writes one escaped line of the database.
+func (w *Writer) line(prefix string, s string) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = io.WriteString(w.out, prefix+Escape(s)+"\n")
+}
+
+// Dump will output a database in the format described by readdb.Header:
 // hash -> array [ filepath1, filepath2, .. ]
+// The database is written to a temporary file next to path which replaces
+// path once it is safely on disk, so a crash half way leaves the old
+// database in place rather than a truncated one.
 func Dump(path string, hashMap map[string][]string) error {
-	//Open File or die
-	file, err := os.Create(path)
+	return dump(path, func(w io.Writer) error {
+		return WriteAll(NewWriter(w), hashMap)
+	})
+}
+
+// dump replaces the file at path with what write writes, only once all of
+// it was written. The rename is flushed to disk along with the contents.
+func dump(path string, write func(w io.Writer) error) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	// create a buffer to make a string as we go along
-	var buffer bytes.Buffer
-
-	//// formatter
-	for hash, filelist := range hashMap {
-		// new entries begin with ---' '
-		buffer.WriteString("--- ")
-		buffer.WriteString(hash)
-		buffer.WriteString("\n")
-		buffer.WriteString("---\n")
-		// iterate through array and add file to yaml formatter
-		for _, filename := range filelist {
-			buffer.WriteString("- ")
-			buffer.WriteString(filename)
-			buffer.WriteString("\n")
-		}
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	// temporary files are only readable by their owner, databases are
+	// created the way os.Create would
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
 	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return syncdir(filepath.Dir(path))
+}
 
-	file.Write(buffer.Bytes())
-	return nil
+// WriteAll writes all entries of hashMap to w in order of their keys and
+// closes it. The values are written in the order they are in, callers that
+// want the same map to always give the same bytes sort lists whose order
+// doesn't matter, such as the paths of a hash.
+func WriteAll(w *Writer, hashMap map[string][]string) error {
+	keys := make([]string, 0, len(hashMap))
+	for hash := range hashMap {
+		keys = append(keys, hash)
+	}
+	sort.Strings(keys)
+	for _, hash := range keys {
+		if err := w.Write(hash, hashMap[hash]); err != nil {
+			return err
+		}
+	}
+	return w.Close()
 }
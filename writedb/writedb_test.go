@@ -0,0 +1,142 @@
+/* Copyright <2018> <Wilyarti Howard>
+*
+* Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+*
+* 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+*
+* 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentatio
+* n and/or other materials provided with the distribution.
+*
+* 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software w
+* ithout specific prior written permission.
+*
+* THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+* IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+* LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+* GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRIC
+* T LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SU
+* CH DAMAGE.
+ */
+package writedb
+
+import (
+	"bytes"
+	"errors"
+	"hashtree-mobile/readdb"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// awkward are names that look like the syntax of the current or the old
+// format, or would break a line.
+var awkward = []string{
+	"plain",
+	"new\nline",
+	"back\\slash",
+	"\\n",
+	"carriage\rreturn",
+	"- dash",
+	"--- triple",
+	"---",
+	"k key",
+	"v value",
+	"sum 0000",
+	"trailing\\",
+	"",
+}
+
+func TestEscape(t *testing.T) {
+	for _, s := range awkward {
+		e := Escape(s)
+		if strings.ContainsAny(e, "\n\r") {
+			t.Errorf("Escape(%q) = %q spans lines", s, e)
+		}
+		if u := readdb.Unescape(e); u != s {
+			t.Errorf("Unescape(Escape(%q)) = %q", s, u)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := make(map[string][]string)
+	for _, s := range awkward {
+		in[s] = awkward
+	}
+	// lines longer than the buffer of the reader
+	in[strings.Repeat("k", 200*1024)] = []string{strings.Repeat("v", 100*1024), "short"}
+	var buf bytes.Buffer
+	if err := WriteAll(NewWriter(&buf), in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := readdb.ReadAll(readdb.NewReader(&buf), make(map[string][]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("read back a different database")
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	in := map[string][]string{"b": {"2", "1"}, "a": {"x"}, "c": nil}
+	var first, second bytes.Buffer
+	WriteAll(NewWriter(&first), in)
+	WriteAll(NewWriter(&second), in)
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("the same map was written differently")
+	}
+	if !strings.HasPrefix(first.String(), readdb.Header+"\nk a\nv x\nk b\nv 2\nv 1\nk c\nsum ") {
+		t.Errorf("unexpected database %q", first.String())
+	}
+}
+
+func TestDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writedb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+	old := map[string][]string{"hash": {"old/file"}}
+	if err := Dump(path, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// a write failing half way leaves the old database alone
+	failed := errors.New("disk full")
+	err = dump(path, func(w io.Writer) error {
+		io.WriteString(w, readdb.Header+"\nk hash\nv new/fi")
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("dump returned %v, want %v", err, failed)
+	}
+	if db, err := readdb.Load(path); err != nil || !reflect.DeepEqual(db, old) {
+		t.Errorf("old database is now %v, %v", db, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("temporary file left behind, %d files", len(files))
+	}
+
+	updated := map[string][]string{"hash": {"new/file"}}
+	if err := Dump(path, updated); err != nil {
+		t.Fatal(err)
+	}
+	if db, err := readdb.Load(path); err != nil || !reflect.DeepEqual(db, updated) {
+		t.Errorf("updated database is %v, %v", db, err)
+	}
+	// windows only keeps whether a file is read only
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0644 {
+		t.Errorf("database has mode %v", info.Mode())
+	}
+}